@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ConfigArmazenamento selects where photos are kept. With "s3" any
+// S3-compatible service (AWS, MinIO, ...) can be used, which lets several
+// replicas share the photos without a shared volume.
+type ConfigArmazenamento struct {
+	Tipo       string `json:"type"` // "local" (default) or "s3"
+	Endpoint   string `json:"endpoint"`
+	Regiao     string `json:"region"`
+	Bucket     string `json:"bucket"`
+	Prefixo    string `json:"prefix"`
+	AccessKey  string `json:"access_key"`
+	SecretKey  string `json:"secret_key"`
+	URLsAssina bool   `json:"signed_urls"`    // redirect browsers to presigned URLs instead of proxying
+	Validade   int    `json:"signed_url_ttl"` // seconds, default 900
+}
+
+// Armazenamento stores the photo files. Names are relative to the photo root
+// and use forward slashes, e.g. "abc.jpg" or "thumbs/abc.jpg".
+type Armazenamento interface {
+	Salvar(nome string, conteudo []byte) error
+	Abrir(nome string) (io.ReadCloser, error) // fs.ErrNotExist when missing
+	Existe(nome string) (bool, error)
+	Remover(nome string) error
+	Listar() ([]ObjetoArmazenado, error)
+}
+
+// URLAssinavel is implemented by stores that can hand out temporary URLs
+// the browser fetches directly.
+type URLAssinavel interface {
+	URLAssinada(nome string, validade time.Duration) (string, error)
+}
+
+type ObjetoArmazenado struct {
+	Nome    string
+	Tamanho int64
+}
+
+var armazenamento Armazenamento
+
+func iniciarArmazenamento() error {
+	c := config.PhotoStorage
+	switch c.Tipo {
+	case "", "local":
+		armazenamento = ArmazenamentoLocal{Raiz: pastaFotos}
+	case "s3":
+		// Credentials may come from the environment, e.g. a Kubernetes secret
+		if c.AccessKey == "" {
+			c.AccessKey = os.Getenv("S3_ACCESS_KEY_ID")
+		}
+		if c.SecretKey == "" {
+			c.SecretKey = os.Getenv("S3_SECRET_ACCESS_KEY")
+		}
+		s3, err := novoArmazenamentoS3(c)
+		if err != nil {
+			return err
+		}
+		armazenamento = s3
+	default:
+		return fmt.Errorf("unknown photo storage type %q", c.Tipo)
+	}
+	return nil
+}
+
+// ArmazenamentoLocal keeps the photos in a directory on disk.
+type ArmazenamentoLocal struct {
+	Raiz string
+}
+
+func (l ArmazenamentoLocal) caminho(nome string) string {
+	// Cleaning against "/" keeps names from escaping the root
+	return filepath.Join(l.Raiz, filepath.FromSlash(path.Clean("/"+nome)))
+}
+
+func (l ArmazenamentoLocal) Salvar(nome string, conteudo []byte) error {
+	caminho := l.caminho(nome)
+	if err := os.MkdirAll(filepath.Dir(caminho), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file and rename it so readers never see a
+	// half-written photo
+	if err := os.WriteFile(caminho+".tmp", conteudo, 0644); err != nil {
+		return err
+	}
+	return os.Rename(caminho+".tmp", caminho)
+}
+
+func (l ArmazenamentoLocal) Abrir(nome string) (io.ReadCloser, error) {
+	f, err := os.Open(l.caminho(nome))
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	return f, nil
+}
+
+func (l ArmazenamentoLocal) Existe(nome string) (bool, error) {
+	_, err := os.Stat(l.caminho(nome))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l ArmazenamentoLocal) Remover(nome string) error {
+	err := os.Remove(l.caminho(nome))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l ArmazenamentoLocal) Listar() ([]ObjetoArmazenado, error) {
+	var objetos []ObjetoArmazenado
+	err := filepath.WalkDir(l.Raiz, func(caminho string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		nome, _ := filepath.Rel(l.Raiz, caminho)
+		objetos = append(objetos, ObjetoArmazenado{Nome: filepath.ToSlash(nome), Tamanho: info.Size()})
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return objetos, err
+}
+
+// servirFoto serves /static/photos/ from the configured store, either by
+// redirecting to a presigned URL or by proxying the file.
+func servirFoto(w http.ResponseWriter, r *http.Request) {
+	nome := strings.TrimPrefix(r.URL.Path, "/static/photos/")
+	if nome == "" || strings.HasPrefix(path.Base(nome), ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The default user picture ships with the application, not the store
+	if nome == fotoPadraoUsuario {
+		http.ServeFile(w, r, filepath.Join(pastaFotos, fotoPadraoUsuario))
+		return
+	}
+
+	if a, ok := armazenamento.(URLAssinavel); ok && config.PhotoStorage.URLsAssina {
+		validade := time.Duration(config.PhotoStorage.Validade) * time.Second
+		if validade <= 0 {
+			validade = 15 * time.Minute
+		}
+		url, err := a.URLAssinada(nome, validade)
+		if err != nil {
+			log.Printf("Error signing photo URL: %v", err)
+			http.Error(w, "Photo unavailable", http.StatusBadGateway)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	rc, err := armazenamento.Abrir(nome)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading photo %s: %v", nome, err)
+		http.Error(w, "Photo unavailable", http.StatusBadGateway)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, nome, time.Time{}, rs)
+		return
+	}
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(nome)))
+	io.Copy(w, rc)
+}
+
+// migrarArmazenamento copies the photos found on local disk into the
+// configured store, skipping the ones already there.
+func migrarArmazenamento() (int, error) {
+	local := ArmazenamentoLocal{Raiz: pastaFotos}
+	if _, ok := armazenamento.(ArmazenamentoLocal); ok {
+		return 0, errors.New("photo storage is already local, set photo_storage in config.json first")
+	}
+	objetos, err := local.Listar()
+	if err != nil {
+		return 0, err
+	}
+	copiadas := 0
+	for _, o := range objetos {
+		if o.Nome == fotoPadraoUsuario {
+			continue
+		}
+		existe, err := armazenamento.Existe(o.Nome)
+		if err != nil {
+			return copiadas, err
+		}
+		if existe {
+			continue
+		}
+		conteudo, err := os.ReadFile(local.caminho(o.Nome))
+		if err != nil {
+			return copiadas, err
+		}
+		if err := armazenamento.Salvar(o.Nome, conteudo); err != nil {
+			return copiadas, err
+		}
+		copiadas++
+	}
+	return copiadas, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+)
+
+type EntradaAuditoria struct {
+	Data     time.Time `json:"data"`
+	Usuario  string    `json:"usuario"`
+	Acao     string    `json:"acao"`
+	IDs      []int     `json:"ids"`
+	Detalhes string    `json:"detalhes"`
+}
+
+var auditoria []EntradaAuditoria
+
+func carregarAuditoria() {
+	file, err := os.ReadFile("auditoria.json")
+	if err == nil {
+		json.Unmarshal(file, &auditoria)
+	}
+}
+
+func salvarAuditoria() {
+	data, _ := json.MarshalIndent(auditoria, "", "  ")
+	os.WriteFile("auditoria.json", data, 0644)
+}
+
+// registrarAuditoria appends one entry for an action that touched the given
+// items, attributed to the user of the current session.
+func registrarAuditoria(r *http.Request, acao string, ids []int, detalhes string) {
+	session, _ := store.Get(r, "session")
+	username, _ := session.Values["username"].(string)
+
+	auditoria = append(auditoria, EntradaAuditoria{
+		Data:     time.Now(),
+		Usuario:  username,
+		Acao:     acao,
+		IDs:      ids,
+		Detalhes: detalhes,
+	})
+	salvarAuditoria()
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Weight of a match in each item field when ranking search results.
+const (
+	pesoNome      = 4.0
+	pesoTag       = 2.5
+	pesoCategoria = 2.0
+	pesoCampo     = 1.5
+	pesoDescricao = 1.0
+
+	// A term that only starts with the searched word counts less than an
+	// exact word match, and a term that is only close to it less still
+	fatorPrefixo    = 0.6
+	fatorAproximado = 0.4
+
+	// How many "did you mean" alternatives to offer
+	maxSugestoes = 3
+)
+
+// IndiceBusca is an in-memory inverted index of the item text, kept up to
+// date as items are created, edited and deleted.
+type IndiceBusca struct {
+	mu       sync.RWMutex
+	postings map[string]map[int]float64 // term -> item ID -> weight
+	termos   []string                   // sorted vocabulary, for prefix matching
+	porItem  map[int][]string           // terms of each item, to remove them on update
+}
+
+var indice = novoIndiceBusca()
+
+func novoIndiceBusca() *IndiceBusca {
+	return &IndiceBusca{
+		postings: make(map[string]map[int]float64),
+		porItem:  make(map[int][]string),
+	}
+}
+
+// acentos folds the accented letters of Portuguese (and other Latin-1
+// languages) so "válvula" and "valvula" are the same word.
+var acentos = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n",
+)
+
+func normalizarTexto(s string) string {
+	return acentos.Replace(strings.ToLower(s))
+}
+
+// tokenizar splits text into lowercase, accent-free words of letters and
+// digits, so "Parafuso M4x10, aço" gives parafuso, m4x10 and aco.
+func tokenizar(s string) []string {
+	return strings.FieldsFunc(normalizarTexto(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// termosItem returns every term of an item with the weight of the best
+// field it appears in.
+func termosItem(item Item) map[string]float64 {
+	termos := make(map[string]float64)
+	adicionar := func(texto string, peso float64) {
+		for _, t := range tokenizar(texto) {
+			if peso > termos[t] {
+				termos[t] = peso
+			}
+		}
+	}
+	adicionar(item.Nome, pesoNome)
+	for _, tag := range item.Tags {
+		adicionar(tag, pesoTag)
+	}
+	adicionar(item.Categoria, pesoCategoria)
+	for _, valor := range item.Campos {
+		adicionar(valor, pesoCampo)
+	}
+	adicionar(item.Descricao, pesoDescricao)
+	return termos
+}
+
+// reconstruirIndice indexes every item from scratch. It runs at startup
+// and after operations that touch many items at once.
+func reconstruirIndice() {
+	novo := novoIndiceBusca()
+	for _, item := range dados.Itens {
+		novo.adicionar(item)
+	}
+	for t := range novo.postings {
+		novo.termos = append(novo.termos, t)
+	}
+	sort.Strings(novo.termos)
+
+	indice.mu.Lock()
+	indice.postings, indice.termos, indice.porItem = novo.postings, novo.termos, novo.porItem
+	indice.mu.Unlock()
+}
+
+// indexarItem adds or refreshes a single item.
+func indexarItem(item Item) {
+	indice.mu.Lock()
+	defer indice.mu.Unlock()
+	indice.remover(item.ID)
+	for _, t := range indice.adicionar(item) {
+		i := sort.SearchStrings(indice.termos, t)
+		indice.termos = append(indice.termos, "")
+		copy(indice.termos[i+1:], indice.termos[i:])
+		indice.termos[i] = t
+	}
+}
+
+func removerDoIndice(id int) {
+	indice.mu.Lock()
+	defer indice.mu.Unlock()
+	indice.remover(id)
+}
+
+// adicionar stores the item postings and returns the terms that were new
+// to the vocabulary. The caller holds the lock.
+func (ix *IndiceBusca) adicionar(item Item) []string {
+	var novos []string
+	termos := termosItem(item)
+	lista := make([]string, 0, len(termos))
+	for t, peso := range termos {
+		p, ok := ix.postings[t]
+		if !ok {
+			p = make(map[int]float64)
+			ix.postings[t] = p
+			novos = append(novos, t)
+		}
+		p[item.ID] = peso
+		lista = append(lista, t)
+	}
+	ix.porItem[item.ID] = lista
+	return novos
+}
+
+func (ix *IndiceBusca) remover(id int) {
+	for _, t := range ix.porItem[id] {
+		p := ix.postings[t]
+		delete(p, id)
+		if len(p) == 0 {
+			delete(ix.postings, t)
+			if i := sort.SearchStrings(ix.termos, t); i < len(ix.termos) && ix.termos[i] == t {
+				ix.termos = append(ix.termos[:i], ix.termos[i+1:]...)
+			}
+		}
+	}
+	delete(ix.porItem, id)
+}
+
+// buscar returns the score of every item matching all the words of the
+// query, either exactly or as the start of a longer word. A word nothing
+// starts with is matched against similar terms instead, so typos still find
+// something; aproximada reports when that happened.
+func (ix *IndiceBusca) buscar(consulta string) (resultado map[int]float64, aproximada bool) {
+	palavras := tokenizar(consulta)
+	if len(palavras) == 0 {
+		return nil, false
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	for _, palavra := range palavras {
+		pontos := ix.pontosPalavra(palavra)
+		if len(pontos) == 0 {
+			pontos = ix.pontosAproximados(palavra)
+			aproximada = true
+		}
+
+		if resultado == nil {
+			resultado = pontos
+			continue
+		}
+		for id := range resultado {
+			if p, ok := pontos[id]; ok {
+				resultado[id] += p
+			} else {
+				delete(resultado, id)
+			}
+		}
+	}
+	return resultado, aproximada
+}
+
+// pontosPalavra returns the best weight of a word for each item, from the
+// terms equal to it or starting with it.
+func (ix *IndiceBusca) pontosPalavra(palavra string) map[int]float64 {
+	pontos := make(map[int]float64)
+	for i := sort.SearchStrings(ix.termos, palavra); i < len(ix.termos) && strings.HasPrefix(ix.termos[i], palavra); i++ {
+		fator := fatorPrefixo
+		if ix.termos[i] == palavra {
+			fator = 1
+		}
+		ix.somar(pontos, ix.termos[i], fator)
+	}
+	return pontos
+}
+
+// pontosAproximados is pontosPalavra for misspelt words: terms within the
+// typos the word length allows, either whole or as the start of the term.
+func (ix *IndiceBusca) pontosAproximados(palavra string) map[int]float64 {
+	pontos := make(map[int]float64)
+	max := distanciaMaxima(palavra)
+	if max == 0 {
+		return pontos
+	}
+	for _, termo := range ix.termos {
+		total, prefixo := distanciaEdicao(palavra, termo)
+		switch {
+		case total <= max:
+			ix.somar(pontos, termo, fatorAproximado)
+		case prefixo <= max:
+			ix.somar(pontos, termo, fatorAproximado*fatorPrefixo)
+		}
+	}
+	return pontos
+}
+
+func (ix *IndiceBusca) somar(pontos map[int]float64, termo string, fator float64) {
+	for id, peso := range ix.postings[termo] {
+		if p := peso * fator; p > pontos[id] {
+			pontos[id] = p
+		}
+	}
+}
+
+// conhece reports whether some term equals or starts with the word.
+func (ix *IndiceBusca) conhece(palavra string) bool {
+	i := sort.SearchStrings(ix.termos, palavra)
+	return i < len(ix.termos) && strings.HasPrefix(ix.termos[i], palavra)
+}
+
+// contem reports whether an item has every word, whole or as the start of
+// one of its terms.
+func (ix *IndiceBusca) contem(id int, palavras []string) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	for _, p := range palavras {
+		achou := false
+		for _, t := range ix.porItem[id] {
+			if strings.HasPrefix(t, p) {
+				achou = true
+				break
+			}
+		}
+		if !achou {
+			return false
+		}
+	}
+	return true
+}
+
+// corrigir returns the term closest to the word, within max typos. Ties go
+// to the term found in more items.
+func (ix *IndiceBusca) corrigir(palavra string, max int) (string, bool) {
+	melhor, menor := "", max+1
+	for _, termo := range ix.termos {
+		total, _ := distanciaEdicao(palavra, termo)
+		if total < menor || (total == menor && melhor != "" && len(ix.postings[termo]) > len(ix.postings[melhor])) {
+			melhor, menor = termo, total
+		}
+	}
+	return melhor, melhor != ""
+}
+
+// distanciaMaxima is how many typos a word tolerates: none below four
+// letters, where almost anything is one typo away from something else.
+func distanciaMaxima(palavra string) int {
+	switch n := utf8.RuneCountInString(palavra); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// distanciaEdicao counts the letters inserted, removed, replaced or swapped
+// with their neighbour to turn a into b, and into the closest start of b.
+func distanciaEdicao(a, b string) (total, prefixo int) {
+	x, y := []rune(a), []rune(b)
+	// Three rows of the usual dynamic programming table are enough
+	anterior2 := make([]int, len(y)+1)
+	anterior := make([]int, len(y)+1)
+	atual := make([]int, len(y)+1)
+	for j := range anterior {
+		anterior[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		atual[0] = i
+		for j := 1; j <= len(y); j++ {
+			custo := 1
+			if x[i-1] == y[j-1] {
+				custo = 0
+			}
+			atual[j] = min(anterior[j]+1, atual[j-1]+1, anterior[j-1]+custo)
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				atual[j] = min(atual[j], anterior2[j-2]+1)
+			}
+		}
+		anterior2, anterior, atual = anterior, atual, anterior2
+	}
+	prefixo = anterior[0]
+	for _, d := range anterior {
+		prefixo = min(prefixo, d)
+	}
+	return anterior[len(y)], prefixo
+}
+
+// sugerirConsultas offers "did you mean" alternatives for a query that found
+// nothing: the query with each unknown word replaced by the closest term,
+// allowing one more typo than the search does, and each corrected word on
+// its own with the same filters. Only alternatives that find items are
+// returned.
+func sugerirConsultas(consulta string) []string {
+	c, err := analisarConsulta(consulta)
+	if err != nil {
+		return nil
+	}
+	corrigida := Consulta{Condicoes: append([]Condicao(nil), c.Condicoes...)}
+	var filtros []Condicao
+	var corrigidas []Condicao
+	indice.mu.RLock()
+	for i, cond := range c.Condicoes {
+		p, ok := cond.(Palavra)
+		if !ok {
+			filtros = append(filtros, cond)
+			continue
+		}
+		palavras := tokenizar(p.Texto)
+		mudou := false
+		for j, palavra := range palavras {
+			if indice.conhece(palavra) {
+				continue
+			}
+			if termo, ok := indice.corrigir(palavra, distanciaMaxima(palavra)+1); ok {
+				palavras[j] = termo
+				mudou = true
+			}
+		}
+		if mudou {
+			corrigida.Condicoes[i] = Palavra{Texto: strings.Join(palavras, " ")}
+		}
+		corrigidas = append(corrigidas, corrigida.Condicoes[i])
+	}
+	indice.mu.RUnlock()
+
+	candidatas := []Consulta{corrigida}
+	if len(corrigidas) > 1 {
+		for _, p := range corrigidas {
+			candidatas = append(candidatas, Consulta{Condicoes: append(append([]Condicao(nil), filtros...), p)})
+		}
+	}
+	vistas := map[string]bool{c.String(): true}
+	var sugestoes []string
+	for _, candidata := range candidatas {
+		texto := candidata.String()
+		if vistas[texto] || len(sugestoes) == maxSugestoes {
+			continue
+		}
+		vistas[texto] = true
+		if pontos, _ := candidata.executar(); len(pontos) > 0 {
+			sugestoes = append(sugestoes, texto)
+		}
+	}
+	return sugestoes
+}
+
+// completarConsulta completes the last word of a query with the terms that
+// start with it, most common first.
+func completarConsulta(consulta string, limite int) []string {
+	inicio, palavra, ok := ultimaPalavra(consulta)
+	if !ok {
+		return nil
+	}
+	palavras := tokenizar(palavra)
+	if len(palavras) != 1 {
+		return nil
+	}
+	ultima := palavras[0]
+
+	// Only offer completions that still find something with what comes
+	// before them
+	var anteriores map[int]float64
+	if strings.TrimSpace(inicio) != "" {
+		c, err := analisarConsulta(inicio)
+		if err != nil {
+			return nil
+		}
+		if anteriores, _ = c.executar(); len(anteriores) == 0 {
+			return nil
+		}
+	}
+
+	indice.mu.RLock()
+	defer indice.mu.RUnlock()
+	var termos []string
+	for i := sort.SearchStrings(indice.termos, ultima); i < len(indice.termos) && strings.HasPrefix(indice.termos[i], ultima); i++ {
+		if indice.termos[i] != ultima && (anteriores == nil || algumEm(indice.postings[indice.termos[i]], anteriores)) {
+			termos = append(termos, indice.termos[i])
+		}
+	}
+	sort.SliceStable(termos, func(i, j int) bool {
+		return len(indice.postings[termos[i]]) > len(indice.postings[termos[j]])
+	})
+
+	var consultas []string
+	for _, t := range termos {
+		if len(consultas) == limite {
+			break
+		}
+		consultas = append(consultas, inicio+t)
+	}
+	return consultas
+}
+
+// algumEm reports whether the two item sets share an item.
+func algumEm(a, b map[int]float64) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	for id := range a {
+		if _, ok := b[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ItemSugerido is an item offered while the search is being typed.
+type ItemSugerido struct {
+	ID    int    `json:"id"`
+	Nome  string `json:"nome"`
+	Local string `json:"local"`
+	URL   string `json:"url"`
+}
+
+// SugestoesBusca is the answer of the search-as-you-type endpoint.
+type SugestoesBusca struct {
+	Consultas  []string       `json:"consultas"`            // completions of the query
+	Itens      []ItemSugerido `json:"itens"`                // best matching items
+	Aproximada bool           `json:"aproximada,omitempty"` // items found by forgiving typos
+	Sugestoes  []string       `json:"sugestoes,omitempty"`  // "did you mean", when nothing matches
+	Erro       string         `json:"erro,omitempty"`       // the query does not parse
+}
+
+// sugerirBusca answers /itens/sugestoes?q=scre&limite=8 for the search box
+// autocomplete.
+func sugerirBusca(w http.ResponseWriter, r *http.Request) {
+	consulta := r.URL.Query().Get("q")
+	limite, _ := strconv.Atoi(r.URL.Query().Get("limite"))
+	if limite < 1 || limite > 50 {
+		limite = 8
+	}
+
+	resposta := SugestoesBusca{Consultas: []string{}, Itens: []ItemSugerido{}}
+	if strings.TrimSpace(consulta) != "" {
+		resposta.Consultas = append(resposta.Consultas, completarConsulta(consulta, 5)...)
+		itens, aproximada, err := buscarItens(consulta)
+		if err != nil {
+			resposta.Erro = err.Error()
+		}
+		resposta.Aproximada = aproximada && len(itens) > 0
+		for _, item := range itens {
+			if len(resposta.Itens) == limite {
+				break
+			}
+			resposta.Itens = append(resposta.Itens, ItemSugerido{
+				ID:    item.ID,
+				Nome:  item.Nome,
+				Local: item.Prateleira + " / " + item.Estante + " / " + item.Compartimento,
+				URL:   "/item?id=" + strconv.Itoa(item.ID),
+			})
+		}
+		if len(itens) == 0 && err == nil {
+			resposta.Sugestoes = sugerirConsultas(consulta)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resposta)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+// indiceDeTeste indexes the items in a fresh index, leaving the global one
+// alone.
+func indiceDeTeste(itens ...Item) *IndiceBusca {
+	ix := novoIndiceBusca()
+	for _, item := range itens {
+		ix.adicionar(item)
+	}
+	for t := range ix.postings {
+		ix.termos = append(ix.termos, t)
+	}
+	sort.Strings(ix.termos)
+	return ix
+}
+
+func TestDistanciaEdicao(t *testing.T) {
+	testes := []struct {
+		a, b           string
+		total, prefixo int
+	}{
+		{"", "", 0, 0},
+		{"", "abc", 3, 0},
+		{"m4", "", 2, 2},
+		{"abc", "abc", 0, 0},
+		{"parafuso", "parafusos", 1, 0}, // a prefix of b
+		{"paraf", "parafuso", 3, 0},     // what the autocomplete sees
+		{"prafuso", "parafuso", 1, 1},   // letter missing
+		{"parafusso", "parafuso", 1, 1}, // letter doubled
+		{"parafuzo", "parafuso", 1, 1},  // letter replaced
+		{"parfauso", "parafuso", 1, 1},  // neighbours swapped
+		{"ab", "ba", 1, 1},              // swap of the whole word
+		{"kitten", "sitting", 3, 2},     // closest start is "sittin"
+		{"válvula", "valvula", 1, 1},    // letters, not bytes
+		{"arruela", "porca", 5, 5},      // little in common
+	}
+	for _, tt := range testes {
+		total, prefixo := distanciaEdicao(tt.a, tt.b)
+		if total != tt.total || prefixo != tt.prefixo {
+			t.Errorf("distanciaEdicao(%q, %q) = %d, %d, want %d, %d", tt.a, tt.b, total, prefixo, tt.total, tt.prefixo)
+		}
+	}
+}
+
+func TestCorrigir(t *testing.T) {
+	ix := indiceDeTeste(
+		Item{ID: 1, Nome: "Parafuso sextavado M4"},
+		Item{ID: 2, Nome: "Parafuso M5"},
+		Item{ID: 3, Nome: "Porca M4"},
+		Item{ID: 4, Nome: "Arruela"},
+		Item{ID: 5, Nome: "Lixa fina"},
+		Item{ID: 6, Nome: "Lixa grossa"},
+		Item{ID: 7, Nome: "Lima chata"},
+	)
+	testes := []struct {
+		palavra string
+		max     int
+		termo   string
+		ok      bool
+	}{
+		{"parafuzo", 1, "parafuso", true},
+		{"porka", 1, "porca", true},
+		{"sextavdo", 1, "sextavado", true},
+		{"arruel", 0, "", false},
+		{"arruel", 1, "arruela", true},
+		{"aruela", 1, "arruela", true},
+		{"xyzw", 2, "", false},
+		{"lida", 1, "lixa", true}, // one typo from lima and lixa, lixa is in more items
+		{"m6", 1, "m4", true},     // m4 is in more items than m5
+	}
+	for _, tt := range testes {
+		termo, ok := ix.corrigir(tt.palavra, tt.max)
+		if termo != tt.termo || ok != tt.ok {
+			t.Errorf("corrigir(%q, %d) = %q, %v, want %q, %v", tt.palavra, tt.max, termo, ok, tt.termo, tt.ok)
+		}
+	}
+}
+
+func TestDistanciaMaxima(t *testing.T) {
+	testes := []struct {
+		palavra string
+		max     int
+	}{
+		{"m4", 0},
+		{"aço", 0},
+		{"lixa", 1},
+		{"parafus", 1},
+		{"parafuso", 2},
+	}
+	for _, tt := range testes {
+		if got := distanciaMaxima(tt.palavra); got != tt.max {
+			t.Errorf("distanciaMaxima(%q) = %d, want %d", tt.palavra, got, tt.max)
+		}
+	}
+}
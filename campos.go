@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CampoPersonalizado is an admin-defined attribute stored in Item.Campos
+// under its Nome. Fields scoped to a category apply to that category and all
+// of its subcategories.
+type CampoPersonalizado struct {
+	Nome        string   `json:"nome"`
+	Rotulo      string   `json:"rotulo"`
+	Tipo        string   `json:"tipo"` // "text", "number", "date", "enum" or "boolean"
+	Opcoes      []string `json:"opcoes,omitempty"`
+	Categoria   string   `json:"categoria,omitempty"`
+	Obrigatorio bool     `json:"obrigatorio"`
+}
+
+// CampoForm is a custom field ready to be rendered in the item forms.
+type CampoForm struct {
+	CampoPersonalizado
+	Valor      string
+	Categorias string // JSON list of categories the field applies to, empty for all
+}
+
+var tiposCampo = []string{"text", "number", "date", "enum", "boolean"}
+
+var nomeCampoValido = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func buscarCampo(nome string) (int, bool) {
+	for i, c := range dados.Campos {
+		if c.Nome == nome {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func campoAplicavel(campo CampoPersonalizado, categoria string) bool {
+	return campo.Categoria == "" || categoriaEDescendentes(campo.Categoria)[categoria]
+}
+
+func montarCamposForm(item Item) []CampoForm {
+	var campos []CampoForm
+	for _, c := range dados.Campos {
+		form := CampoForm{CampoPersonalizado: c, Valor: item.Campos[c.Nome]}
+		if c.Categoria != "" {
+			var categorias []string
+			for nome := range categoriaEDescendentes(c.Categoria) {
+				categorias = append(categorias, nome)
+			}
+			escopo, _ := json.Marshal(categorias)
+			form.Categorias = string(escopo)
+		}
+		campos = append(campos, form)
+	}
+	return campos
+}
+
+// validarValorCampo checks a raw form value against the field type and
+// returns it in its stored form.
+func validarValorCampo(campo CampoPersonalizado, valor string) (string, error) {
+	valor = strings.TrimSpace(valor)
+	if valor == "" {
+		if campo.Obrigatorio && campo.Tipo != "boolean" {
+			return "", fmt.Errorf("%s is required", campo.Rotulo)
+		}
+		return "", nil
+	}
+
+	switch campo.Tipo {
+	case "number":
+		n, err := strconv.ParseFloat(strings.Replace(valor, ",", ".", 1), 64)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a number", campo.Rotulo)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case "date":
+		if _, err := time.Parse("2006-01-02", valor); err != nil {
+			return "", fmt.Errorf("%s must be a date (YYYY-MM-DD)", campo.Rotulo)
+		}
+	case "enum":
+		if !contem(campo.Opcoes, valor) {
+			return "", fmt.Errorf("%s must be one of: %s", campo.Rotulo, strings.Join(campo.Opcoes, ", "))
+		}
+	case "boolean":
+		if valor != "true" {
+			return "", nil
+		}
+	}
+	return valor, nil
+}
+
+// camposDoForm validates the custom fields that apply to the given category
+// and returns their values. Fields outside the category are dropped.
+func camposDoForm(r *http.Request, categoria string) (map[string]string, error) {
+	valores := map[string]string{}
+	for _, campo := range dados.Campos {
+		if !campoAplicavel(campo, categoria) {
+			continue
+		}
+		valor, err := validarValorCampo(campo, r.FormValue("campo_"+campo.Nome))
+		if err != nil {
+			return nil, err
+		}
+		if valor != "" {
+			valores[campo.Nome] = valor
+		}
+	}
+	if len(valores) == 0 {
+		return nil, nil
+	}
+	return valores, nil
+}
+
+func campoDoForm(r *http.Request) CampoPersonalizado {
+	var opcoes []string
+	for _, opcao := range strings.Split(r.FormValue("opcoes"), ",") {
+		if opcao = strings.TrimSpace(opcao); opcao != "" {
+			opcoes = append(opcoes, opcao)
+		}
+	}
+	return CampoPersonalizado{
+		Nome:        strings.ToLower(strings.TrimSpace(r.FormValue("nome"))),
+		Rotulo:      strings.TrimSpace(r.FormValue("rotulo")),
+		Tipo:        r.FormValue("tipo"),
+		Opcoes:      opcoes,
+		Categoria:   r.FormValue("categoria"),
+		Obrigatorio: r.FormValue("obrigatorio") == "true",
+	}
+}
+
+func validarCampo(c CampoPersonalizado) error {
+	if !contem(tiposCampo, c.Tipo) {
+		return fmt.Errorf("Unknown field type %s", c.Tipo)
+	}
+	if c.Rotulo == "" {
+		return fmt.Errorf("Label is required")
+	}
+	if c.Tipo == "enum" && len(c.Opcoes) == 0 {
+		return fmt.Errorf("Enum fields need at least one option")
+	}
+	return nil
+}
+
+func renderCampos(w http.ResponseWriter, erro string) {
+	tmpl := template.Must(template.ParseFiles("templates/campos.html"))
+	tmpl.Execute(w, struct {
+		Campos     []CampoPersonalizado
+		Tipos      []string
+		Categorias []CategoriaArvore
+		Error      string
+	}{
+		Campos:     dados.Campos,
+		Tipos:      tiposCampo,
+		Categorias: arvoreCategorias(),
+		Error:      erro,
+	})
+}
+
+func listarCampos(w http.ResponseWriter, r *http.Request) {
+	renderCampos(w, "")
+}
+
+func novoCampo(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		c := campoDoForm(r)
+		if !nomeCampoValido.MatchString(c.Nome) {
+			renderCampos(w, "Key must use only lowercase letters, digits and underscores")
+			return
+		}
+		if _, existe := buscarCampo(c.Nome); existe {
+			renderCampos(w, "Field "+c.Nome+" already exists")
+			return
+		}
+		if err := validarCampo(c); err != nil {
+			renderCampos(w, err.Error())
+			return
+		}
+		dados.Campos = append(dados.Campos, c)
+		salvarDados()
+		http.Redirect(w, r, "/campos", http.StatusSeeOther)
+	}
+}
+
+// editarCampo updates everything but the key, which items use to store the
+// values.
+func editarCampo(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		c := campoDoForm(r)
+		i, ok := buscarCampo(c.Nome)
+		if !ok {
+			http.Error(w, "Field not found", http.StatusNotFound)
+			return
+		}
+		if err := validarCampo(c); err != nil {
+			renderCampos(w, err.Error())
+			return
+		}
+		valores, err := revalidarValoresCampo(c)
+		if err != nil {
+			renderCampos(w, err.Error())
+			return
+		}
+		dados.Campos[i] = c
+		for j, valor := range valores {
+			dados.Itens[j].Campos[c.Nome] = valor
+			dados.Itens[j].Modificado = time.Now()
+		}
+		if len(valores) > 0 {
+			reconstruirIndice()
+		}
+		salvarDados()
+		http.Redirect(w, r, "/campos", http.StatusSeeOther)
+	}
+}
+
+// revalidarValoresCampo checks the values items hold for a field against
+// its new settings, so a change of type, options or category cannot leave
+// values behind that the next edit of the item would reject or drop. It
+// returns the values whose stored form changes, by item position, or an
+// error listing the items to fix first.
+func revalidarValoresCampo(c CampoPersonalizado) (map[int]string, error) {
+	const maxListados = 10
+	convertidos := map[int]string{}
+	var problemas []string
+	for j, item := range dados.Itens {
+		antigo, ok := item.Campos[c.Nome]
+		if !ok || antigo == "" {
+			continue
+		}
+		var motivo string
+		if !campoAplicavel(c, item.Categoria) {
+			motivo = "not in category " + c.Categoria
+		} else if valor, err := validarValorCampo(c, antigo); err != nil {
+			motivo = err.Error()
+		} else if valor == "" {
+			motivo = fmt.Sprintf("%q is not true", antigo)
+		} else if valor != antigo {
+			convertidos[j] = valor
+		}
+		if motivo != "" {
+			problemas = append(problemas, fmt.Sprintf("Item %d (%s): %s", item.ID, item.Nome, motivo))
+		}
+	}
+	if len(problemas) == 0 {
+		return convertidos, nil
+	}
+	if len(problemas) > maxListados {
+		problemas = append(problemas[:maxListados], fmt.Sprintf("and %d more", len(problemas)-maxListados))
+	}
+	return nil, fmt.Errorf("Items hold values that do not fit the new settings, change or clear them first: %s", strings.Join(problemas, "; "))
+}
+
+func deletarCampo(w http.ResponseWriter, r *http.Request) {
+	nome := r.URL.Query().Get("nome")
+	if i, ok := buscarCampo(nome); ok {
+		dados.Campos = append(dados.Campos[:i], dados.Campos[i+1:]...)
+
+		// Remove os valores deste campo dos itens
+		for j, item := range dados.Itens {
+			if _, ok := item.Campos[nome]; ok {
+				delete(dados.Itens[j].Campos, nome)
+				dados.Itens[j].Modificado = time.Now()
+			}
+		}
+		reconstruirIndice()
+		salvarDados()
+	}
+	http.Redirect(w, r, "/campos", http.StatusSeeOther)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRevalidarValoresCampo(t *testing.T) {
+	usarDados(t, Inventario{
+		Categorias: []Categoria{{Nome: "Fixacao"}, {Nome: "Parafusos", Pai: "Fixacao"}, {Nome: "Ferramentas"}},
+		Itens: []Item{
+			{ID: 1, Nome: "Parafuso", Categoria: "Parafusos", Campos: map[string]string{"medida": "1,5"}},
+			{ID: 2, Nome: "Chave", Categoria: "Ferramentas", Campos: map[string]string{"medida": "10"}},
+			{ID: 3, Nome: "Lixa", Campos: map[string]string{"medida": "fina"}},
+			{ID: 4, Nome: "Porca", Categoria: "Fixacao", Campos: map[string]string{"medida": ""}},
+			{ID: 5, Nome: "Lima", Categoria: "Ferramentas"},
+		},
+	})
+
+	testes := []struct {
+		nome        string
+		campo       CampoPersonalizado
+		convertidos map[int]string
+		erros       []string // items listed in the error, nil when there is none
+	}{
+		{
+			"text accepts everything", CampoPersonalizado{Tipo: "text"},
+			map[int]string{}, nil,
+		},
+		{
+			"number normalizes and rejects words", CampoPersonalizado{Tipo: "number"},
+			nil, []string{"Item 3 (Lixa): Size must be a number"},
+		},
+		{
+			"options must include the values", CampoPersonalizado{Tipo: "enum", Opcoes: []string{"10", "fina"}},
+			nil, []string{"Item 1 (Parafuso): Size must be one of: 10, fina"},
+		},
+		{
+			"boolean takes only true", CampoPersonalizado{Tipo: "boolean"},
+			nil, []string{`Item 1 (Parafuso): "1,5" is not true`, `Item 2 (Chave): "10" is not true`, `Item 3 (Lixa): "fina" is not true`},
+		},
+		{
+			"category leaves items out", CampoPersonalizado{Tipo: "text", Categoria: "Fixacao"},
+			nil, []string{"Item 2 (Chave): not in category Fixacao", "Item 3 (Lixa): not in category Fixacao"},
+		},
+		{
+			"required is not checked", CampoPersonalizado{Tipo: "text", Obrigatorio: true},
+			map[int]string{}, nil,
+		},
+	}
+	for _, tt := range testes {
+		t.Run(tt.nome, func(t *testing.T) {
+			tt.campo.Nome, tt.campo.Rotulo = "medida", "Size"
+			convertidos, err := revalidarValoresCampo(tt.campo)
+			if tt.erros == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(convertidos, tt.convertidos) {
+					t.Errorf("converted %v, want %v", convertidos, tt.convertidos)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("no error, converted %v", convertidos)
+			}
+			if got := err.Error()[strings.Index(err.Error(), ": ")+2:]; got != strings.Join(tt.erros, "; ") {
+				t.Errorf("listed %q, want %q", got, strings.Join(tt.erros, "; "))
+			}
+		})
+	}
+}
+
+func TestRevalidarValoresCampoConverte(t *testing.T) {
+	usarDados(t, Inventario{
+		Itens: []Item{
+			{ID: 1, Nome: "Parafuso", Campos: map[string]string{"medida": "1,5"}},
+			{ID: 2, Nome: "Porca", Campos: map[string]string{"medida": "10"}},
+		},
+	})
+	convertidos, err := revalidarValoresCampo(CampoPersonalizado{Nome: "medida", Rotulo: "Size", Tipo: "number"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]string{0: "1.5"}; !reflect.DeepEqual(convertidos, want) {
+		t.Errorf("converted %v, want %v", convertidos, want)
+	}
+}
+
+func TestRevalidarValoresCampoLimite(t *testing.T) {
+	var itens []Item
+	for id := 1; id <= 12; id++ {
+		itens = append(itens, Item{ID: id, Nome: "x", Campos: map[string]string{"medida": "abc"}})
+	}
+	usarDados(t, Inventario{Itens: itens})
+	_, err := revalidarValoresCampo(CampoPersonalizado{Nome: "medida", Rotulo: "Size", Tipo: "number"})
+	if err == nil || !strings.HasSuffix(err.Error(), "Item 10 (x): Size must be a number; and 2 more") {
+		t.Errorf("got %v", err)
+	}
+}
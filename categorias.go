@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Categoria is a node of the category tree. Pai holds the name of the
+// parent category and is empty for top-level categories.
+type Categoria struct {
+	Nome string `json:"nome"`
+	Pai  string `json:"pai"`
+}
+
+type CategoriaArvore struct {
+	Categoria
+	Caminho string
+	Nivel   int
+}
+
+type TagContagem struct {
+	Nome  string
+	Itens int
+}
+
+func buscarCategoria(nome string) (int, bool) {
+	for i, c := range dados.Categorias {
+		if c.Nome == nome {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// caminhoCategoria returns the full path of a category, e.g.
+// "Fasteners / Screws".
+func caminhoCategoria(nome string) string {
+	partes := []string{nome}
+	visitadas := map[string]bool{nome: true}
+	for {
+		i, ok := buscarCategoria(partes[0])
+		if !ok || dados.Categorias[i].Pai == "" || visitadas[dados.Categorias[i].Pai] {
+			break
+		}
+		visitadas[dados.Categorias[i].Pai] = true
+		partes = append([]string{dados.Categorias[i].Pai}, partes...)
+	}
+	return strings.Join(partes, " / ")
+}
+
+// categoriaEDescendentes returns the category together with every category
+// below it in the tree.
+func categoriaEDescendentes(nome string) map[string]bool {
+	resultado := map[string]bool{nome: true}
+	for mudou := true; mudou; {
+		mudou = false
+		for _, c := range dados.Categorias {
+			if resultado[c.Pai] && !resultado[c.Nome] {
+				resultado[c.Nome] = true
+				mudou = true
+			}
+		}
+	}
+	return resultado
+}
+
+// arvoreCategorias flattens the category tree in depth-first order so
+// templates can render it with indentation.
+func arvoreCategorias() []CategoriaArvore {
+	var resultado []CategoriaArvore
+	var visitar func(pai string, nivel int)
+	visitar = func(pai string, nivel int) {
+		var filhas []Categoria
+		for _, c := range dados.Categorias {
+			// A category that is its own parent would never end
+			if c.Pai == pai && c.Nome != pai {
+				filhas = append(filhas, c)
+			}
+		}
+		sort.Slice(filhas, func(i, j int) bool { return filhas[i].Nome < filhas[j].Nome })
+		for _, c := range filhas {
+			resultado = append(resultado, CategoriaArvore{Categoria: c, Caminho: caminhoCategoria(c.Nome), Nivel: nivel})
+			visitar(c.Nome, nivel+1)
+		}
+	}
+	visitar("", 0)
+	return resultado
+}
+
+// contarTags lists every tag in use with the number of items carrying it.
+func contarTags() []TagContagem {
+	contagem := map[string]int{}
+	for _, item := range dados.Itens {
+		for _, tag := range item.Tags {
+			contagem[tag]++
+		}
+	}
+	var tags []TagContagem
+	for nome, n := range contagem {
+		tags = append(tags, TagContagem{Nome: nome, Itens: n})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Nome < tags[j].Nome })
+	return tags
+}
+
+func nomesTags() []string {
+	var nomes []string
+	for _, tag := range contarTags() {
+		nomes = append(nomes, tag.Nome)
+	}
+	return nomes
+}
+
+// normalizarTag puts a tag in the form it is stored in: lowercase and
+// without surrounding blanks.
+func normalizarTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// normalizarTags splits a comma separated list into lowercase tags without
+// blanks or duplicates.
+func normalizarTags(s string) []string {
+	var tags []string
+	vistas := map[string]bool{}
+	for _, tag := range strings.Split(s, ",") {
+		tag = normalizarTag(tag)
+		if tag != "" && !vistas[tag] {
+			vistas[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func contem(lista []string, valor string) bool {
+	for _, v := range lista {
+		if v == valor {
+			return true
+		}
+	}
+	return false
+}
+
+func renderCategorias(w http.ResponseWriter, erro string) {
+	tmpl := template.Must(template.ParseFiles("templates/categorias.html"))
+	tmpl.Execute(w, struct {
+		Categorias []CategoriaArvore
+		Error      string
+	}{
+		Categorias: arvoreCategorias(),
+		Error:      erro,
+	})
+}
+
+// validarCategoria checks the name and parent sent by the category forms.
+func validarCategoria(nome, pai string) string {
+	if nome == "" {
+		return "Category name is required"
+	}
+	if _, existe := buscarCategoria(pai); pai != "" && !existe {
+		return "Parent category " + pai + " does not exist"
+	}
+	return ""
+}
+
+// verificarCategoria checks the category given to an item, which may be
+// left empty.
+func verificarCategoria(nome string) error {
+	if _, existe := buscarCategoria(nome); nome != "" && !existe {
+		return fmt.Errorf("Category %s does not exist", nome)
+	}
+	return nil
+}
+
+func listarCategorias(w http.ResponseWriter, r *http.Request) {
+	renderCategorias(w, "")
+}
+
+func novaCategoria(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		nome := strings.TrimSpace(r.FormValue("nome"))
+		pai := r.FormValue("pai")
+		if erro := validarCategoria(nome, pai); erro != "" {
+			renderCategorias(w, erro)
+			return
+		}
+		if _, existe := buscarCategoria(nome); existe {
+			renderCategorias(w, "Category "+nome+" already exists")
+			return
+		}
+		dados.Categorias = append(dados.Categorias, Categoria{Nome: nome, Pai: pai})
+		salvarDados()
+		http.Redirect(w, r, "/categorias", http.StatusSeeOther)
+	}
+}
+
+func editarCategoria(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		nomeAntigo := r.FormValue("nome_antigo")
+		nomeNovo := strings.TrimSpace(r.FormValue("nome_novo"))
+		pai := r.FormValue("pai")
+
+		if erro := validarCategoria(nomeNovo, pai); erro != "" {
+			renderCategorias(w, erro)
+			return
+		}
+		if nomeNovo != nomeAntigo {
+			if _, existe := buscarCategoria(nomeNovo); existe {
+				renderCategorias(w, "Category "+nomeNovo+" already exists")
+				return
+			}
+		}
+		// A category cannot be moved below itself or one of its children
+		if categoriaEDescendentes(nomeAntigo)[pai] {
+			renderCategorias(w, "A category cannot be placed under itself or its subcategories")
+			return
+		}
+
+		// Atualiza a categoria
+		for i, c := range dados.Categorias {
+			if c.Nome == nomeAntigo {
+				dados.Categorias[i] = Categoria{Nome: nomeNovo, Pai: pai}
+				break
+			}
+		}
+
+		// Atualiza as subcategorias e os itens que usam esta categoria
+		for i, c := range dados.Categorias {
+			if c.Pai == nomeAntigo {
+				dados.Categorias[i].Pai = nomeNovo
+			}
+		}
+		for i, item := range dados.Itens {
+			if item.Categoria == nomeAntigo {
+				dados.Itens[i].Categoria = nomeNovo
+				dados.Itens[i].Modificado = time.Now()
+			}
+		}
+
+		reconstruirIndice()
+		salvarDados()
+		http.Redirect(w, r, "/categorias", http.StatusSeeOther)
+	}
+}
+
+// deletarCategoria removes a category, moving its subcategories and items up
+// to its parent.
+func deletarCategoria(w http.ResponseWriter, r *http.Request) {
+	nome := r.URL.Query().Get("nome")
+	i, ok := buscarCategoria(nome)
+	if !ok {
+		http.Redirect(w, r, "/categorias", http.StatusSeeOther)
+		return
+	}
+	pai := dados.Categorias[i].Pai
+	dados.Categorias = append(dados.Categorias[:i], dados.Categorias[i+1:]...)
+
+	for j, c := range dados.Categorias {
+		if c.Pai == nome {
+			dados.Categorias[j].Pai = pai
+		}
+	}
+	for j, item := range dados.Itens {
+		if item.Categoria == nome {
+			dados.Itens[j].Categoria = pai
+			dados.Itens[j].Modificado = time.Now()
+		}
+	}
+
+	reconstruirIndice()
+	salvarDados()
+	http.Redirect(w, r, "/categorias", http.StatusSeeOther)
+}
+
+func listarTags(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.ParseFiles("templates/tags.html"))
+	tmpl.Execute(w, struct {
+		Tags []TagContagem
+	}{
+		Tags: contarTags(),
+	})
+}
+
+// editarTag renames a tag on every item. Renaming onto an existing tag
+// merges both.
+func editarTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		nomeAntigo := r.FormValue("nome_antigo")
+		nomeNovo := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(r.FormValue("nome_novo"), ",", " ")))
+		if nomeNovo == "" {
+			http.Redirect(w, r, "/tags", http.StatusSeeOther)
+			return
+		}
+
+		for i, item := range dados.Itens {
+			if contem(item.Tags, nomeAntigo) {
+				var tags []string
+				for _, tag := range item.Tags {
+					if tag == nomeAntigo {
+						tag = nomeNovo
+					}
+					tags = append(tags, tag)
+				}
+				dados.Itens[i].Tags = normalizarTags(strings.Join(tags, ","))
+				dados.Itens[i].Modificado = time.Now()
+			}
+		}
+
+		reconstruirIndice()
+		salvarDados()
+		http.Redirect(w, r, "/tags", http.StatusSeeOther)
+	}
+}
+
+func deletarTag(w http.ResponseWriter, r *http.Request) {
+	nome := r.URL.Query().Get("nome")
+	for i, item := range dados.Itens {
+		if contem(item.Tags, nome) {
+			var tags []string
+			for _, tag := range item.Tags {
+				if tag != nome {
+					tags = append(tags, tag)
+				}
+			}
+			dados.Itens[i].Tags = tags
+			dados.Itens[i].Modificado = time.Now()
+		}
+	}
+	reconstruirIndice()
+	salvarDados()
+	http.Redirect(w, r, "/tags", http.StatusSeeOther)
+}
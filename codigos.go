@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/qr"
+)
+
+// Location codes printed on rack and shelf labels. Item labels carry the item
+// URL in the QR code, so any phone opens the item page, and the shorter item
+// code in the Code128 barcode.
+const (
+	prefixoItem    = "ITEM:"
+	prefixoRack    = "RACK:"
+	prefixoEstante = "SHELF:"
+)
+
+func codigoItem(id int) string {
+	return prefixoItem + strconv.Itoa(id)
+}
+
+func codigoRack(nome string) string {
+	return prefixoRack + nome
+}
+
+func codigoEstante(nome string) string {
+	return prefixoEstante + nome
+}
+
+// urlBase returns the address the inventory is reached at, preferring
+// public_url so labels stay valid behind a reverse proxy.
+func urlBase(r *http.Request) string {
+	if config.PublicURL != "" {
+		return strings.TrimRight(config.PublicURL, "/")
+	}
+	esquema := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		esquema = "https"
+	}
+	return esquema + "://" + r.Host
+}
+
+func urlItem(r *http.Request, id int) string {
+	return urlBase(r) + "/item?id=" + strconv.Itoa(id)
+}
+
+// textoCodigo finds what a code request refers to and returns the text to
+// encode and a name for the downloaded file.
+func textoCodigo(r *http.Request, tipo string) (texto, arquivo string, ok bool) {
+	q := r.URL.Query()
+	switch {
+	case q.Has("item"):
+		id, _ := strconv.Atoi(q.Get("item"))
+		if _, ok := buscarItem(id); !ok {
+			return "", "", false
+		}
+		if tipo == "code128" {
+			return codigoItem(id), "item-" + strconv.Itoa(id), true
+		}
+		return urlItem(r, id), "item-" + strconv.Itoa(id), true
+	case q.Has("rack"):
+		nome := q.Get("rack")
+		for _, rack := range dados.Racks {
+			if rack.Nome == nome {
+				return codigoRack(nome), "rack-" + nome, true
+			}
+		}
+	case q.Has("estante"):
+		nome := q.Get("estante")
+		for _, e := range dados.Estantes {
+			if e.Nome == nome {
+				return codigoEstante(nome), "shelf-" + nome, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func gerarCodigo(texto, tipo string) (barcode.Barcode, error) {
+	switch tipo {
+	case "qr":
+		return qr.Encode(texto, qr.M, qr.Auto)
+	case "code128":
+		return code128.Encode(texto)
+	}
+	return nil, fmt.Errorf("unknown code type %q", tipo)
+}
+
+// ModulosCodigo is a barcode laid out as a grid of dark and light modules,
+// including the quiet zone scanners need around it.
+type ModulosCodigo struct {
+	Largura, Altura int
+	escuro          func(x, y int) bool
+}
+
+func (m ModulosCodigo) Escuro(x, y int) bool {
+	return m.escuro(x, y)
+}
+
+func modulosCodigo(bc barcode.Barcode) ModulosCodigo {
+	b := bc.Bounds()
+	if b.Dy() <= 1 {
+		// Linear barcode: 10 modules of quiet zone on each side and bars 50
+		// modules high
+		const margem, altura = 10, 50
+		return ModulosCodigo{
+			Largura: b.Dx() + 2*margem,
+			Altura:  altura,
+			escuro: func(x, y int) bool {
+				x -= margem
+				return x >= 0 && x < b.Dx() && ehEscuro(bc.At(b.Min.X+x, b.Min.Y))
+			},
+		}
+	}
+	const margem = 4
+	return ModulosCodigo{
+		Largura: b.Dx() + 2*margem,
+		Altura:  b.Dy() + 2*margem,
+		escuro: func(x, y int) bool {
+			x, y = x-margem, y-margem
+			return x >= 0 && y >= 0 && x < b.Dx() && y < b.Dy() && ehEscuro(bc.At(b.Min.X+x, b.Min.Y+y))
+		},
+	}
+}
+
+func ehEscuro(c color.Color) bool {
+	return color.GrayModel.Convert(c).(color.Gray).Y < 128
+}
+
+func desenharCodigoPNG(m ModulosCodigo, escala int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, m.Largura*escala, m.Altura*escala))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for y := 0; y < m.Altura; y++ {
+		for x := 0; x < m.Largura; x++ {
+			if !m.Escuro(x, y) {
+				continue
+			}
+			for py := y * escala; py < (y+1)*escala; py++ {
+				for px := x * escala; px < (x+1)*escala; px++ {
+					img.Pix[py*img.Stride+px] = 0
+				}
+			}
+		}
+	}
+	return img
+}
+
+// desenharCodigoSVG draws each run of dark modules in a row as one rectangle.
+func desenharCodigoSVG(m ModulosCodigo, escala int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		m.Largura*escala, m.Altura*escala, m.Largura, m.Altura)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, m.Largura, m.Altura)
+	for y := 0; y < m.Altura; y++ {
+		for x := 0; x < m.Largura; {
+			if !m.Escuro(x, y) {
+				x++
+				continue
+			}
+			inicio := x
+			for x < m.Largura && m.Escuro(x, y) {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", inicio, y, x-inicio, x-inicio)
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// servirCodigo serves the QR code or Code128 barcode of an item, rack or
+// shelf, e.g. /codigo?item=6&tipo=qr&formato=svg.
+func servirCodigo(w http.ResponseWriter, r *http.Request) {
+	tipo := r.URL.Query().Get("tipo")
+	if tipo == "" {
+		tipo = "qr"
+	}
+	texto, arquivo, ok := textoCodigo(r, tipo)
+	if !ok {
+		http.Error(w, "Item or location not found", http.StatusNotFound)
+		return
+	}
+	bc, err := gerarCodigo(texto, tipo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m := modulosCodigo(bc)
+
+	escala, _ := strconv.Atoi(r.URL.Query().Get("escala"))
+	if escala < 1 || escala > 20 {
+		escala = 4
+	}
+
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": arquivo + "-" + tipo + "." + formatoCodigo(r)}))
+	}
+	switch formatoCodigo(r) {
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		fmt.Fprint(w, desenharCodigoSVG(m, escala))
+	default:
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, desenharCodigoPNG(m, escala))
+	}
+}
+
+func formatoCodigo(r *http.Request) string {
+	if r.URL.Query().Get("formato") == "svg" {
+		return "svg"
+	}
+	return "png"
+}
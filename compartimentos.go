@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Compartimento is a bin inside a rack/shelf pair. Capacidade is the number
+// of items the bin holds: 1 for single-item bins, more for multi-item bins
+// and 0 for no limit.
+type Compartimento struct {
+	Prateleira   string `json:"prateleira"`
+	Estante      string `json:"estante"`
+	Nome         string `json:"nome"`
+	Largura      int    `json:"largura"`      // mm
+	Altura       int    `json:"altura"`       // mm
+	Profundidade int    `json:"profundidade"` // mm
+	Capacidade   int    `json:"capacidade"`
+}
+
+type CelulaGrade struct {
+	Compartimento Compartimento
+	Definido      bool
+	Itens         []Item
+	Livre         bool
+}
+
+type LinhaGrade struct {
+	Estante string
+	Celulas []CelulaGrade
+}
+
+func buscarCompartimento(prateleira, estante, nome string) (int, bool) {
+	for i, c := range dados.Compartimentos {
+		if c.Prateleira == prateleira && c.Estante == estante && c.Nome == nome {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func possuiCompartimentos(prateleira, estante string) bool {
+	for _, c := range dados.Compartimentos {
+		if c.Prateleira == prateleira && c.Estante == estante {
+			return true
+		}
+	}
+	return false
+}
+
+// ocupacao counts the items stored in a location, ignoring the item with
+// ID ignorarID (used when an item is being edited or moved).
+func ocupacao(prateleira, estante, compartimento string, ignorarID int) int {
+	total := 0
+	for _, item := range dados.Itens {
+		if item.ID != ignorarID && item.Prateleira == prateleira && item.Estante == estante && item.Compartimento == compartimento {
+			total++
+		}
+	}
+	return total
+}
+
+// validarLocalizacao checks whether an item can be placed in a location.
+// Rack/shelf pairs with defined compartments only accept those compartments
+// up to their capacity; pairs without any keep the one-item-per-location rule.
+func validarLocalizacao(prateleira, estante, compartimento string, ignorarID int) error {
+	return verificarCapacidade(prateleira, estante, compartimento, ocupacao(prateleira, estante, compartimento, ignorarID))
+}
+
+// verificarCapacidade applies the rules of validarLocalizacao to a location
+// already holding ocupados items.
+func verificarCapacidade(prateleira, estante, compartimento string, ocupados int) error {
+	if !possuiCompartimentos(prateleira, estante) {
+		if ocupados > 0 {
+			return fmt.Errorf("An item already exists in this location (Shelf: %s, Rack: %s, Compartment: %s)", estante, prateleira, compartimento)
+		}
+		return nil
+	}
+
+	i, ok := buscarCompartimento(prateleira, estante, compartimento)
+	if !ok {
+		return fmt.Errorf("Compartment %s is not defined for Rack %s, Shelf %s", compartimento, prateleira, estante)
+	}
+	capacidade := dados.Compartimentos[i].Capacidade
+	if capacidade > 0 && ocupados >= capacidade {
+		return fmt.Errorf("Compartment %s (Shelf: %s, Rack: %s) is full (%d/%d)", compartimento, estante, prateleira, ocupados, capacidade)
+	}
+	return nil
+}
+
+// montarGrade builds the shelf × compartment grid of a rack. Defined
+// compartments come first in their configured order, followed by free-text
+// compartments that items reference without a definition.
+func montarGrade(prateleira string) []LinhaGrade {
+	var estantes []string
+	vistas := map[string]bool{}
+	adicionarEstante := func(nome string) {
+		if !vistas[nome] {
+			vistas[nome] = true
+			estantes = append(estantes, nome)
+		}
+	}
+	for _, est := range dados.Estantes {
+		adicionarEstante(est.Nome)
+	}
+	for _, item := range dados.Itens {
+		if item.Prateleira == prateleira {
+			adicionarEstante(item.Estante)
+		}
+	}
+	for _, c := range dados.Compartimentos {
+		if c.Prateleira == prateleira {
+			adicionarEstante(c.Estante)
+		}
+	}
+
+	var linhas []LinhaGrade
+	for _, estante := range estantes {
+		linha := LinhaGrade{Estante: estante}
+		definidos := map[string]bool{}
+
+		for _, c := range dados.Compartimentos {
+			if c.Prateleira == prateleira && c.Estante == estante {
+				definidos[c.Nome] = true
+				linha.Celulas = append(linha.Celulas, CelulaGrade{Compartimento: c, Definido: true})
+			}
+		}
+
+		var avulsos []string
+		for _, item := range dados.Itens {
+			if item.Prateleira == prateleira && item.Estante == estante && !definidos[item.Compartimento] {
+				definidos[item.Compartimento] = true
+				avulsos = append(avulsos, item.Compartimento)
+			}
+		}
+		sort.Strings(avulsos)
+		for _, nome := range avulsos {
+			linha.Celulas = append(linha.Celulas, CelulaGrade{
+				Compartimento: Compartimento{Prateleira: prateleira, Estante: estante, Nome: nome, Capacidade: 1},
+			})
+		}
+
+		for i := range linha.Celulas {
+			celula := &linha.Celulas[i]
+			for _, item := range dados.Itens {
+				if item.Prateleira == prateleira && item.Estante == estante && item.Compartimento == celula.Compartimento.Nome {
+					celula.Itens = append(celula.Itens, item)
+				}
+			}
+			capacidade := celula.Compartimento.Capacidade
+			celula.Livre = capacidade <= 0 || len(celula.Itens) < capacidade
+		}
+
+		if len(linha.Celulas) > 0 {
+			linhas = append(linhas, linha)
+		}
+	}
+	return linhas
+}
+
+func compartimentoDoForm(r *http.Request) Compartimento {
+	largura, _ := strconv.Atoi(r.FormValue("largura"))
+	altura, _ := strconv.Atoi(r.FormValue("altura"))
+	profundidade, _ := strconv.Atoi(r.FormValue("profundidade"))
+	capacidade, _ := strconv.Atoi(r.FormValue("capacidade"))
+	if capacidade < 0 {
+		capacidade = 0
+	}
+	return Compartimento{
+		Prateleira:   r.FormValue("prateleira"),
+		Estante:      r.FormValue("estante"),
+		Nome:         r.FormValue("nome"),
+		Largura:      largura,
+		Altura:       altura,
+		Profundidade: profundidade,
+		Capacidade:   capacidade,
+	}
+}
+
+func renderCompartimentos(w http.ResponseWriter, r *http.Request, erro string) {
+	rack := r.FormValue("rack")
+	var lista []Compartimento
+	for _, c := range dados.Compartimentos {
+		if rack == "" || c.Prateleira == rack {
+			lista = append(lista, c)
+		}
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/compartimentos.html"))
+	tmpl.Execute(w, struct {
+		Compartimentos []Compartimento
+		Estantes       []Estante
+		Racks          []Rack
+		Rack           string
+		Error          string
+		Config         Config
+	}{
+		Compartimentos: lista,
+		Estantes:       dados.Estantes,
+		Racks:          dados.Racks,
+		Rack:           rack,
+		Error:          erro,
+		Config:         config,
+	})
+}
+
+func listarCompartimentos(w http.ResponseWriter, r *http.Request) {
+	renderCompartimentos(w, r, "")
+}
+
+func novoCompartimento(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		c := compartimentoDoForm(r)
+		if c.Prateleira == "" || c.Estante == "" || c.Nome == "" {
+			renderCompartimentos(w, r, "Rack, shelf and label are required")
+			return
+		}
+		if _, ok := buscarCompartimento(c.Prateleira, c.Estante, c.Nome); ok {
+			renderCompartimentos(w, r, fmt.Sprintf("Compartment %s already exists in Rack %s, Shelf %s", c.Nome, c.Prateleira, c.Estante))
+			return
+		}
+		dados.Compartimentos = append(dados.Compartimentos, c)
+		salvarDados()
+		http.Redirect(w, r, "/compartimentos?rack="+url.QueryEscape(c.Prateleira), http.StatusSeeOther)
+	}
+}
+
+func editarCompartimento(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		c := compartimentoDoForm(r)
+		nomeAntigo := r.FormValue("nome_antigo")
+
+		i, ok := buscarCompartimento(c.Prateleira, c.Estante, nomeAntigo)
+		if !ok {
+			http.Error(w, "Compartment not found", http.StatusNotFound)
+			return
+		}
+		if c.Nome != nomeAntigo {
+			if _, existe := buscarCompartimento(c.Prateleira, c.Estante, c.Nome); existe {
+				renderCompartimentos(w, r, fmt.Sprintf("Compartment %s already exists in Rack %s, Shelf %s", c.Nome, c.Prateleira, c.Estante))
+				return
+			}
+		}
+		ocupados := ocupacao(c.Prateleira, c.Estante, nomeAntigo, 0)
+		if c.Capacidade > 0 && ocupados > c.Capacidade {
+			renderCompartimentos(w, r, fmt.Sprintf("Compartment %s holds %d items, capacity cannot be %d", nomeAntigo, ocupados, c.Capacidade))
+			return
+		}
+
+		dados.Compartimentos[i] = c
+
+		// Atualiza os itens que usam este compartimento
+		for j, item := range dados.Itens {
+			if item.Prateleira == c.Prateleira && item.Estante == c.Estante && item.Compartimento == nomeAntigo {
+				dados.Itens[j].Compartimento = c.Nome
+				dados.Itens[j].Modificado = time.Now()
+			}
+		}
+
+		salvarDados()
+		http.Redirect(w, r, "/compartimentos?rack="+url.QueryEscape(c.Prateleira), http.StatusSeeOther)
+	}
+}
+
+func deletarCompartimento(w http.ResponseWriter, r *http.Request) {
+	prateleira := r.URL.Query().Get("prateleira")
+	estante := r.URL.Query().Get("estante")
+	nome := r.URL.Query().Get("nome")
+
+	if ocupados := ocupacao(prateleira, estante, nome, 0); ocupados > 0 {
+		http.Error(w, fmt.Sprintf("Compartment %s still holds %d item(s)", nome, ocupados), http.StatusBadRequest)
+		return
+	}
+	if i, ok := buscarCompartimento(prateleira, estante, nome); ok {
+		dados.Compartimentos = append(dados.Compartimentos[:i], dados.Compartimentos[i+1:]...)
+		salvarDados()
+	}
+	http.Redirect(w, r, "/compartimentos?rack="+url.QueryEscape(prateleira), http.StatusSeeOther)
+}
+
+func ocupacaoRack(w http.ResponseWriter, r *http.Request) {
+	nome := r.URL.Query().Get("nome")
+
+	tmpl := template.Must(template.ParseFiles("templates/rack_ocupacao.html"))
+	tmpl.Execute(w, struct {
+		Rack   string
+		Linhas []LinhaGrade
+		Config Config
+	}{
+		Rack:   nome,
+		Linhas: montarGrade(nome),
+		Config: config,
+	})
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The search box accepts a small query language on top of free text:
+//
+//	estante:L1 rack:P-0001 tag:fastener qty<10 "exact phrase" -excluded
+//
+// Every part must hold for an item to match. Free words go through the
+// search index, so they are ranked and forgive typos; a leading "-" excludes
+// whatever follows it.
+
+// Condicao is a node of a parsed query.
+type Condicao interface {
+	String() string
+}
+
+// Palavra is a free-text word, matched as the start of an indexed word.
+type Palavra struct {
+	Texto string
+}
+
+// Frase is a quoted phrase whose words must appear together, in order, in
+// one field of the item.
+type Frase struct {
+	Palavras []string
+}
+
+// Filtro compares a location, tag or category with a value, ignoring case
+// and accents.
+type Filtro struct {
+	Campo string // "estante", "rack", "compartimento", "tag" or "categoria"
+	Valor string
+}
+
+// Comparacao compares the stock quantity with a number.
+type Comparacao struct {
+	Operador string // "<", "<=", ">", ">=" or "="
+	Valor    int
+}
+
+// Negacao matches the items its condition does not.
+type Negacao struct {
+	Condicao Condicao
+}
+
+// Consulta is a parsed query.
+type Consulta struct {
+	Condicoes []Condicao
+}
+
+// ErroConsulta is a syntax error in a query, Posicao being the character it
+// was found at, starting from 1.
+type ErroConsulta struct {
+	Posicao  int
+	Mensagem string
+}
+
+func (e *ErroConsulta) Error() string {
+	return fmt.Sprintf("%s (at character %d)", e.Mensagem, e.Posicao)
+}
+
+// camposFiltro maps the filter names accepted in queries, in Portuguese and
+// English, to the field they filter.
+var camposFiltro = map[string]string{
+	"estante":       "estante",
+	"shelf":         "estante",
+	"rack":          "rack",
+	"prateleira":    "rack",
+	"compartimento": "compartimento",
+	"compartment":   "compartimento",
+	"tag":           "tag",
+	"categoria":     "categoria",
+	"category":      "categoria",
+	"qty":           "qty",
+	"quantidade":    "qty",
+}
+
+const nomesFiltros = "estante, rack, compartimento, tag, categoria or qty"
+
+func (p Palavra) String() string { return p.Texto }
+
+func (f Frase) String() string { return `"` + strings.Join(f.Palavras, " ") + `"` }
+
+func (f Filtro) String() string { return f.Campo + ":" + valorConsulta(f.Valor) }
+
+func (c Comparacao) String() string { return "qty" + c.Operador + strconv.Itoa(c.Valor) }
+
+func (n Negacao) String() string { return "-" + n.Condicao.String() }
+
+func (c Consulta) String() string {
+	partes := make([]string, len(c.Condicoes))
+	for i, cond := range c.Condicoes {
+		partes[i] = cond.String()
+	}
+	return strings.Join(partes, " ")
+}
+
+// valorConsulta quotes filter values that would not survive being parsed
+// again as a bare word.
+func valorConsulta(v string) string {
+	if v == "" || strings.ContainsFunc(v, func(r rune) bool { return unicode.IsSpace(r) || r == '"' }) {
+		return `"` + strings.ReplaceAll(v, `"`, "") + `"`
+	}
+	return v
+}
+
+// analisador reads a query one rune at a time.
+type analisador struct {
+	texto []rune
+	pos   int
+}
+
+// analisarConsulta parses the text of the search box.
+func analisarConsulta(texto string) (Consulta, error) {
+	a := &analisador{texto: []rune(texto)}
+	var c Consulta
+	for {
+		a.pularEspacos()
+		if a.fim() {
+			return c, nil
+		}
+		cond, err := a.condicao()
+		if err != nil {
+			return Consulta{}, err
+		}
+		if cond != nil {
+			c.Condicoes = append(c.Condicoes, cond)
+		}
+	}
+}
+
+func (a *analisador) fim() bool {
+	return a.pos >= len(a.texto)
+}
+
+func (a *analisador) atual() rune {
+	return a.texto[a.pos]
+}
+
+func (a *analisador) pularEspacos() {
+	for !a.fim() && unicode.IsSpace(a.atual()) {
+		a.pos++
+	}
+}
+
+func (a *analisador) erro(pos int, formato string, args ...any) error {
+	return &ErroConsulta{Posicao: pos + 1, Mensagem: fmt.Sprintf(formato, args...)}
+}
+
+// condicao reads one part of the query. It returns nil for words made only
+// of punctuation, which the index would ignore anyway.
+func (a *analisador) condicao() (Condicao, error) {
+	inicio := a.pos
+	if a.atual() == '-' {
+		a.pos++
+		if a.fim() || unicode.IsSpace(a.atual()) {
+			return nil, a.erro(inicio, `"-" must be followed by what to exclude, e.g. -rusty`)
+		}
+		cond, err := a.condicao()
+		if err != nil || cond == nil {
+			return nil, err
+		}
+		if _, ok := cond.(Negacao); ok {
+			return nil, a.erro(inicio, "a condition can only be excluded once")
+		}
+		return Negacao{Condicao: cond}, nil
+	}
+	if a.atual() == '"' {
+		frase, err := a.entreAspas()
+		if err != nil {
+			return nil, err
+		}
+		palavras := tokenizar(frase)
+		if len(palavras) == 0 {
+			return nil, a.erro(inicio, "empty phrase")
+		}
+		return Frase{Palavras: palavras}, nil
+	}
+
+	// Filters start with a name followed by an operator
+	nome := a.pos
+	for !a.fim() && unicode.IsLetter(a.atual()) {
+		a.pos++
+	}
+	if a.pos > nome && !a.fim() && strings.ContainsRune(":<>=", a.atual()) {
+		campo := strings.ToLower(string(a.texto[nome:a.pos]))
+		if destino, ok := camposFiltro[campo]; ok {
+			return a.filtro(inicio, campo, destino)
+		}
+		if a.atual() == ':' {
+			return nil, a.erro(inicio, "unknown filter %q, use %s", campo, nomesFiltros)
+		}
+	}
+
+	a.pos = inicio
+	for !a.fim() && !unicode.IsSpace(a.atual()) && a.atual() != '"' {
+		a.pos++
+	}
+	palavra := string(a.texto[inicio:a.pos])
+	if len(tokenizar(palavra)) == 0 {
+		return nil, nil
+	}
+	return Palavra{Texto: palavra}, nil
+}
+
+// filtro reads the operator and value of a filter whose name was just read.
+func (a *analisador) filtro(inicio int, campo, destino string) (Condicao, error) {
+	operador := string(a.atual())
+	a.pos++
+	if !a.fim() && a.atual() == '=' && operador != "=" && operador != ":" {
+		operador += "="
+		a.pos++
+	}
+
+	var valor string
+	if !a.fim() && a.atual() == '"' {
+		v, err := a.entreAspas()
+		if err != nil {
+			return nil, err
+		}
+		valor = strings.TrimSpace(v)
+	} else {
+		comeco := a.pos
+		for !a.fim() && !unicode.IsSpace(a.atual()) && a.atual() != '"' {
+			a.pos++
+		}
+		valor = string(a.texto[comeco:a.pos])
+	}
+	if valor == "" {
+		return nil, a.erro(inicio, "%s%s needs a value", campo, operador)
+	}
+
+	if destino == "qty" {
+		n, err := strconv.Atoi(valor)
+		if err != nil {
+			return nil, a.erro(inicio, "%s needs a whole number, not %q", campo, valor)
+		}
+		if operador == ":" {
+			operador = "="
+		}
+		return Comparacao{Operador: operador, Valor: n}, nil
+	}
+	if operador != ":" && operador != "=" {
+		return nil, a.erro(inicio, "%s can only be compared with \":\", e.g. %s:%s", campo, campo, valorConsulta(valor))
+	}
+	return Filtro{Campo: destino, Valor: valor}, nil
+}
+
+// entreAspas reads a quoted string starting at the current position.
+func (a *analisador) entreAspas() (string, error) {
+	inicio := a.pos
+	a.pos++
+	for !a.fim() && a.atual() != '"' {
+		a.pos++
+	}
+	if a.fim() {
+		return "", a.erro(inicio, "missing closing quote")
+	}
+	a.pos++
+	return string(a.texto[inicio+1 : a.pos-1]), nil
+}
+
+// textoLivre returns the words and phrases the items must contain, which
+// the search index looks up and ranks.
+func (c Consulta) textoLivre() string {
+	var partes []string
+	for _, cond := range c.Condicoes {
+		switch cond := cond.(type) {
+		case Palavra:
+			partes = append(partes, cond.Texto)
+		case Frase:
+			partes = append(partes, cond.Palavras...)
+		}
+	}
+	return strings.Join(partes, " ")
+}
+
+// executar returns the score of every item matching the query, and whether
+// typos in the free text were forgiven. Without free text every item
+// matching the filters scores zero.
+func (c Consulta) executar() (map[int]float64, bool) {
+	var pontos map[int]float64
+	var aproximada bool
+	if texto := c.textoLivre(); texto != "" {
+		pontos, aproximada = indice.buscar(texto)
+	} else {
+		pontos = make(map[int]float64, len(dados.Itens))
+		for _, item := range dados.Itens {
+			pontos[item.ID] = 0
+		}
+	}
+
+	// Free words were already matched by the index, typos included
+	var testes []func(Item) bool
+	for _, cond := range c.Condicoes {
+		if _, ok := cond.(Palavra); !ok {
+			testes = append(testes, compilarCondicao(cond))
+		}
+	}
+	if len(testes) > 0 {
+		for _, item := range dados.Itens {
+			if _, ok := pontos[item.ID]; !ok {
+				continue
+			}
+			for _, teste := range testes {
+				if !teste(item) {
+					delete(pontos, item.ID)
+					break
+				}
+			}
+		}
+	}
+	return pontos, aproximada
+}
+
+// compilarCondicao turns a query node into a test of a single item.
+func compilarCondicao(cond Condicao) func(Item) bool {
+	switch cond := cond.(type) {
+	case Negacao:
+		teste := compilarCondicao(cond.Condicao)
+		return func(item Item) bool { return !teste(item) }
+	case Palavra:
+		palavras := tokenizar(cond.Texto)
+		return func(item Item) bool { return indice.contem(item.ID, palavras) }
+	case Frase:
+		return func(item Item) bool {
+			for _, texto := range textosItem(item) {
+				if contemSequencia(tokenizar(texto), cond.Palavras) {
+					return true
+				}
+			}
+			return false
+		}
+	case Comparacao:
+		return func(item Item) bool {
+			switch cond.Operador {
+			case "<":
+				return item.Quantidade < cond.Valor
+			case "<=":
+				return item.Quantidade <= cond.Valor
+			case ">":
+				return item.Quantidade > cond.Valor
+			case ">=":
+				return item.Quantidade >= cond.Valor
+			}
+			return item.Quantidade == cond.Valor
+		}
+	case Filtro:
+		valor := normalizarTexto(cond.Valor)
+		igual := func(s string) bool { return normalizarTexto(s) == valor }
+		switch cond.Campo {
+		case "estante":
+			return func(item Item) bool { return igual(item.Estante) }
+		case "rack":
+			return func(item Item) bool { return igual(item.Prateleira) }
+		case "compartimento":
+			return func(item Item) bool { return igual(item.Compartimento) }
+		case "tag":
+			return func(item Item) bool {
+				for _, tag := range item.Tags {
+					if igual(tag) {
+						return true
+					}
+				}
+				return false
+			}
+		case "categoria":
+			// Like the category menu, a category includes its subcategories
+			categorias := map[string]bool{}
+			for _, c := range dados.Categorias {
+				if igual(c.Nome) {
+					categorias = categoriaEDescendentes(c.Nome)
+					break
+				}
+			}
+			return func(item Item) bool { return categorias[item.Categoria] }
+		}
+	}
+	return func(Item) bool { return false }
+}
+
+// textosItem lists the searchable fields of an item, each on its own so a
+// phrase cannot span two of them.
+func textosItem(item Item) []string {
+	textos := []string{item.Nome, item.Descricao, item.Categoria}
+	textos = append(textos, item.Tags...)
+	for _, valor := range item.Campos {
+		textos = append(textos, valor)
+	}
+	return textos
+}
+
+func contemSequencia(palavras, sequencia []string) bool {
+	for i := 0; i+len(sequencia) <= len(palavras); i++ {
+		igual := true
+		for j, p := range sequencia {
+			if palavras[i+j] != p {
+				igual = false
+				break
+			}
+		}
+		if igual {
+			return true
+		}
+	}
+	return false
+}
+
+// ResultadoBusca is the answer of the JSON search API.
+type ResultadoBusca struct {
+	Consulta   string   `json:"consulta"` // the query as it was understood
+	Total      int      `json:"total"`
+	Pagina     int      `json:"pagina"`
+	Paginas    int      `json:"paginas"`
+	Aproximada bool     `json:"aproximada,omitempty"`
+	Itens      []Item   `json:"itens"`
+	Facetas    []Faceta `json:"facetas"`
+}
+
+// buscarItensJSON searches the items with the same query language and
+// filters as the item list, e.g. /itens/buscar?q=rack:P-0001+qty<5&foto=nao,
+// and returns the facet counts of the results.
+func buscarItensJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	consulta, err := analisarConsulta(q.Get("q"))
+	if err != nil {
+		resposta := map[string]any{"erro": err.Error()}
+		if e, ok := err.(*ErroConsulta); ok {
+			resposta["posicao"] = e.Posicao
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(resposta)
+		return
+	}
+	itens, aproximada := itensDaConsulta(consulta)
+	dimensoes := dimensoesFaceta()
+	itens, contagens := agregarFacetas(itens, filtrosLista(q), dimensoes)
+	if ordem := q.Get("ordem"); ordemValida(ordem) {
+		ordenarItens(itens, ordem)
+	}
+
+	porPagina, _ := strconv.Atoi(q.Get("por_pagina"))
+	if porPagina < 1 || porPagina > 500 {
+		porPagina = config.ItemsPerPage
+	}
+	pagina, _ := strconv.Atoi(q.Get("pagina"))
+	paginas := max((len(itens)+porPagina-1)/porPagina, 1)
+	pagina = min(max(pagina, 1), paginas)
+	inicio := min((pagina-1)*porPagina, len(itens))
+	fim := min(inicio+porPagina, len(itens))
+
+	json.NewEncoder(w).Encode(ResultadoBusca{
+		Consulta:   consulta.String(),
+		Total:      len(itens),
+		Pagina:     pagina,
+		Paginas:    paginas,
+		Aproximada: aproximada && len(itens) > 0,
+		Itens:      append([]Item{}, itens[inicio:fim]...),
+		Facetas:    montarFacetas(dimensoes, contagens, estadoLista(q)),
+	})
+}
+
+// buscarItens parses a query and returns the items matching it, best matches
+// first, and whether typos had to be forgiven to find them.
+func buscarItens(consulta string) ([]Item, bool, error) {
+	c, err := analisarConsulta(consulta)
+	if err != nil {
+		return nil, false, err
+	}
+	itens, aproximada := itensDaConsulta(c)
+	return itens, aproximada, nil
+}
+
+// itensDaBusca returns the items the list starts from: the search results
+// ranked by relevance, or every item newest first when there is no search.
+func itensDaBusca(busca string) ([]Item, bool, error) {
+	if busca != "" {
+		return buscarItens(busca)
+	}
+	itens := append([]Item(nil), dados.Itens...)
+	sort.Slice(itens, func(i, j int) bool {
+		return itens[i].ID > itens[j].ID
+	})
+	return itens, false, nil
+}
+
+// itensDaConsulta returns the items matching a parsed query, best matches
+// first and newest first among equals.
+func itensDaConsulta(c Consulta) ([]Item, bool) {
+	pontos, aproximada := c.executar()
+	var itens []Item
+	for _, item := range dados.Itens {
+		if _, ok := pontos[item.ID]; ok {
+			itens = append(itens, item)
+		}
+	}
+	sort.SliceStable(itens, func(i, j int) bool {
+		pi, pj := pontos[itens[i].ID], pontos[itens[j].ID]
+		if pi != pj {
+			return pi > pj
+		}
+		return itens[i].ID > itens[j].ID
+	})
+	return itens, aproximada
+}
+
+// ultimaPalavra splits a query being typed into what comes before its last
+// free word and the word itself, or returns false when the query ends in a
+// filter, a phrase, an exclusion or a space.
+func ultimaPalavra(consulta string) (inicio, palavra string, ok bool) {
+	fim, _ := utf8.DecodeLastRuneInString(consulta)
+	if !unicode.IsLetter(fim) && !unicode.IsDigit(fim) {
+		return "", "", false
+	}
+	i := strings.LastIndexFunc(consulta, unicode.IsSpace)
+	inicio, palavra = consulta[:i+1], consulta[i+1:]
+	if strings.ContainsAny(palavra, `:<>="`) || strings.HasPrefix(palavra, "-") || strings.Count(inicio, `"`)%2 == 1 {
+		return "", "", false
+	}
+	return inicio, palavra, true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAnalisarConsulta(t *testing.T) {
+	testes := []struct {
+		texto     string
+		condicoes []Condicao
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"parafuso m4", []Condicao{Palavra{"parafuso"}, Palavra{"m4"}}},
+		{"estante:L1", []Condicao{Filtro{"estante", "L1"}}},
+		{"shelf:L1", []Condicao{Filtro{"estante", "L1"}}},
+		{"Rack:P-0001", []Condicao{Filtro{"rack", "P-0001"}}},
+		{"prateleira=P-0001", []Condicao{Filtro{"rack", "P-0001"}}},
+		{"compartment:3", []Condicao{Filtro{"compartimento", "3"}}},
+		{"category:Fasteners", []Condicao{Filtro{"categoria", "Fasteners"}}},
+		{`tag:"fastener kit"`, []Condicao{Filtro{"tag", "fastener kit"}}},
+		{`tag:" spaced "`, []Condicao{Filtro{"tag", "spaced"}}},
+		{"qty<10", []Condicao{Comparacao{"<", 10}}},
+		{"qty<=10", []Condicao{Comparacao{"<=", 10}}},
+		{"qty>0", []Condicao{Comparacao{">", 0}}},
+		{"qty>=2", []Condicao{Comparacao{">=", 2}}},
+		{"quantidade:5", []Condicao{Comparacao{"=", 5}}},
+		{"qty=5", []Condicao{Comparacao{"=", 5}}},
+		{`"exact phrase"`, []Condicao{Frase{[]string{"exact", "phrase"}}}},
+		{`"Aço Inox"`, []Condicao{Frase{[]string{"aco", "inox"}}}},
+		{"-rusty", []Condicao{Negacao{Palavra{"rusty"}}}},
+		{"-tag:old", []Condicao{Negacao{Filtro{"tag", "old"}}}},
+		{`-"broken bit"`, []Condicao{Negacao{Frase{[]string{"broken", "bit"}}}}},
+		{"-qty>5", []Condicao{Negacao{Comparacao{">", 5}}}},
+		{"!!! m4", []Condicao{Palavra{"m4"}}},
+		{"a=b", []Condicao{Palavra{"a=b"}}},
+		{"m4:x", []Condicao{Palavra{"m4:x"}}},
+		{
+			`estante:L1 rack:P-0001 tag:fastener qty<10 "exact phrase" -excluded`,
+			[]Condicao{
+				Filtro{"estante", "L1"},
+				Filtro{"rack", "P-0001"},
+				Filtro{"tag", "fastener"},
+				Comparacao{"<", 10},
+				Frase{[]string{"exact", "phrase"}},
+				Negacao{Palavra{"excluded"}},
+			},
+		},
+	}
+	for _, tt := range testes {
+		c, err := analisarConsulta(tt.texto)
+		if err != nil {
+			t.Errorf("analisarConsulta(%q): %v", tt.texto, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.Condicoes, tt.condicoes) {
+			t.Errorf("analisarConsulta(%q) = %#v, want %#v", tt.texto, c.Condicoes, tt.condicoes)
+		}
+	}
+}
+
+func TestAnalisarConsultaErros(t *testing.T) {
+	testes := []struct {
+		texto   string
+		posicao int
+	}{
+		{"foo:bar", 1},
+		{"m4 estante:", 4},
+		{"qty<abc", 1},
+		{"rack<5", 1},
+		{`m4 "open`, 4},
+		{`""`, 1},
+		{"- x", 1},
+		{"parafuso --x", 10},
+		{`tag:"open`, 5},
+	}
+	for _, tt := range testes {
+		_, err := analisarConsulta(tt.texto)
+		var erro *ErroConsulta
+		if !errors.As(err, &erro) {
+			t.Errorf("analisarConsulta(%q) = %v, want a query error", tt.texto, err)
+			continue
+		}
+		if erro.Posicao != tt.posicao {
+			t.Errorf("analisarConsulta(%q) error at %d, want %d: %v", tt.texto, erro.Posicao, tt.posicao, err)
+		}
+	}
+}
+
+// A parsed query prints back as a query that parses to the same thing, which
+// the saved searches and suggestions rely on.
+func TestConsultaString(t *testing.T) {
+	testes := []struct {
+		texto, impresso string
+	}{
+		{"parafuso  m4", "parafuso m4"},
+		{`Shelf:L1 tag:"fastener kit"`, `estante:L1 tag:"fastener kit"`},
+		{"quantidade:5 -qty>=10", "qty=5 -qty>=10"},
+		{`-"Aço Inox"`, `-"aco inox"`},
+	}
+	for _, tt := range testes {
+		c, err := analisarConsulta(tt.texto)
+		if err != nil {
+			t.Fatalf("analisarConsulta(%q): %v", tt.texto, err)
+		}
+		if got := c.String(); got != tt.impresso {
+			t.Errorf("analisarConsulta(%q).String() = %q, want %q", tt.texto, got, tt.impresso)
+		}
+		outra, err := analisarConsulta(c.String())
+		if err != nil || !reflect.DeepEqual(outra, c) {
+			t.Errorf("%q parses again as %#v, %v", c.String(), outra, err)
+		}
+	}
+}
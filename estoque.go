@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errQuantidade = errors.New("Quantity must be a whole number, zero or more")
+
+// quantidadeDoForm reads the stock quantity of the item forms. An empty
+// field means zero.
+func quantidadeDoForm(r *http.Request) (int, error) {
+	valor := strings.TrimSpace(r.FormValue("quantidade"))
+	if valor == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(valor)
+	if err != nil || n < 0 {
+		return 0, errQuantidade
+	}
+	return n, nil
+}
+
+// migrarQuantidades gives one unit of stock to the items saved before the
+// quantity existed, since each of them stood for a single thing on the
+// shelf. Left at zero they would show as out of stock. arquivo is the
+// dados.json just loaded.
+func migrarQuantidades(arquivo []byte) {
+	var salvos struct {
+		Itens []struct {
+			Quantidade *int `json:"quantidade"`
+		} `json:"itens"`
+	}
+	if json.Unmarshal(arquivo, &salvos) != nil || len(salvos.Itens) != len(dados.Itens) {
+		return
+	}
+	for i, item := range salvos.Itens {
+		if item.Quantidade == nil {
+			dados.Itens[i].Quantidade = 1
+		}
+	}
+}
+
+// limiteEstoqueBaixo is the quantity at or below which an item is low on
+// stock, one unit when low_stock_threshold is not configured.
+func limiteEstoqueBaixo() int {
+	if config.LowStockThreshold <= 0 {
+		return 1
+	}
+	return config.LowStockThreshold
+}
+
+func estoqueBaixo(item Item) bool {
+	return item.Quantidade <= limiteEstoqueBaixo()
+}
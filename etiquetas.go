@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// FormatoEtiqueta is an A4 sticker sheet. Measurements are in millimetres,
+// Passo being the distance from the start of one label to the next.
+type FormatoEtiqueta struct {
+	Nome            string
+	Descricao       string
+	Colunas, Linhas int
+	Largura, Altura float64
+	Esquerda, Topo  float64
+	PassoX, PassoY  float64
+}
+
+func (f FormatoEtiqueta) PorFolha() int {
+	return f.Colunas * f.Linhas
+}
+
+var formatosEtiqueta = []FormatoEtiqueta{
+	{"L7160", "Avery L7160 – 21 per sheet, 63.5 × 38.1 mm", 3, 7, 63.5, 38.1, 7.25, 15.15, 66.04, 38.1},
+	{"L7163", "Avery L7163 – 14 per sheet, 99.1 × 38.1 mm", 2, 7, 99.1, 38.1, 4.65, 15.15, 101.6, 38.1},
+	{"L7165", "Avery L7165 – 8 per sheet, 99.1 × 67.7 mm", 2, 4, 99.1, 67.7, 4.65, 13.1, 101.6, 67.7},
+	{"L7651", "Avery L7651 – 65 per sheet, 38.1 × 21.2 mm", 5, 13, 38.1, 21.2, 4.75, 10.7, 40.6, 21.2},
+}
+
+func buscarFormatoEtiqueta(nome string) FormatoEtiqueta {
+	for _, f := range formatosEtiqueta {
+		if f.Nome == nome {
+			return f
+		}
+	}
+	return formatosEtiqueta[0]
+}
+
+// Etiqueta is one label: what is printed and what the QR code encodes.
+type Etiqueta struct {
+	Tipo     string // "item", "rack" or "estante"
+	Titulo   string
+	Local    string
+	Codigo   string
+	Conteudo string
+	Foto     string
+}
+
+// etiquetasSelecionadas builds the labels for the items, racks and shelves
+// selected in the request, optionally followed by the items stored in the
+// selected racks and shelves.
+func etiquetasSelecionadas(r *http.Request) []Etiqueta {
+	var etiquetas []Etiqueta
+	incluidos := make(map[int]bool)
+	adicionarItem := func(item Item) {
+		if incluidos[item.ID] {
+			return
+		}
+		incluidos[item.ID] = true
+		etiquetas = append(etiquetas, Etiqueta{
+			Tipo:     "item",
+			Titulo:   item.Nome,
+			Local:    item.Prateleira + " / " + item.Estante + " / " + item.Compartimento,
+			Codigo:   codigoItem(item.ID),
+			Conteudo: urlItem(r, item.ID),
+			Foto:     item.Foto,
+		})
+	}
+
+	for _, id := range idsSelecionados(r) {
+		if i, ok := buscarItem(id); ok {
+			adicionarItem(dados.Itens[i])
+		}
+	}
+	racks := make(map[string]bool)
+	for _, nome := range r.Form["rack"] {
+		for _, rack := range dados.Racks {
+			if rack.Nome == nome && !racks[nome] {
+				racks[nome] = true
+				etiquetas = append(etiquetas, Etiqueta{Tipo: "rack", Titulo: "Rack " + nome, Codigo: codigoRack(nome), Conteudo: codigoRack(nome)})
+			}
+		}
+	}
+	estantes := make(map[string]bool)
+	for _, nome := range r.Form["estante"] {
+		for _, e := range dados.Estantes {
+			if e.Nome == nome && !estantes[nome] {
+				estantes[nome] = true
+				etiquetas = append(etiquetas, Etiqueta{Tipo: "estante", Titulo: "Shelf " + nome, Codigo: codigoEstante(nome), Conteudo: codigoEstante(nome)})
+			}
+		}
+	}
+	if r.FormValue("incluir_itens") != "" {
+		for _, item := range dados.Itens {
+			if racks[item.Prateleira] || estantes[item.Estante] {
+				adicionarItem(item)
+			}
+		}
+	}
+	return etiquetas
+}
+
+// listarEtiquetas shows the selected labels and lets the user pick the
+// sticker sheet before downloading the PDF, or print them on the thermal
+// printer.
+func listarEtiquetas(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	renderEtiquetas(w, r, "", "")
+}
+
+func renderEtiquetas(w http.ResponseWriter, r *http.Request, erro, sucesso string) {
+	tmpl := template.Must(template.ParseFiles("templates/etiquetas.html"))
+	tmpl.Execute(w, struct {
+		Etiquetas  []Etiqueta
+		Formatos   []FormatoEtiqueta
+		Itens      []string
+		Racks      []string
+		Estantes   []string
+		Incluir    bool
+		Impressora string
+		Error      string
+		Sucesso    string
+	}{
+		Etiquetas:  etiquetasSelecionadas(r),
+		Formatos:   formatosEtiqueta,
+		Itens:      r.Form["id"],
+		Racks:      r.Form["rack"],
+		Estantes:   r.Form["estante"],
+		Incluir:    r.FormValue("incluir_itens") != "",
+		Impressora: config.LabelPrinter.Endereco,
+		Error:      erro,
+		Sucesso:    sucesso,
+	})
+}
+
+func baixarEtiquetasPDF(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	etiquetas := etiquetasSelecionadas(r)
+	if len(etiquetas) == 0 {
+		http.Error(w, "No items or locations selected", http.StatusBadRequest)
+		return
+	}
+	formato := buscarFormatoEtiqueta(r.FormValue("formato"))
+	inicio, _ := strconv.Atoi(r.FormValue("inicio"))
+
+	var buf bytes.Buffer
+	if err := gerarEtiquetasPDF(&buf, etiquetas, formato, inicio-1); err != nil {
+		log.Printf("Error generating labels: %v", err)
+		http.Error(w, "Could not generate the labels", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="labels-`+formato.Nome+`.pdf"`)
+	w.Write(buf.Bytes())
+}
+
+// gerarEtiquetasPDF lays the labels out on A4 sheets. pular skips the first
+// positions of the first sheet so partly used sheets can be fed again.
+func gerarEtiquetasPDF(w io.Writer, etiquetas []Etiqueta, f FormatoEtiqueta, pular int) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	if pular < 0 || pular >= f.PorFolha() {
+		pular = 0
+	}
+	for n, e := range etiquetas {
+		pos := (n + pular) % f.PorFolha()
+		if n == 0 || pos == 0 {
+			pdf.AddPage()
+		}
+		x := f.Esquerda + float64(pos%f.Colunas)*f.PassoX
+		y := f.Topo + float64(pos/f.Colunas)*f.PassoY
+		desenharEtiqueta(pdf, tr, e, x, y, f.Largura, f.Altura)
+	}
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
+
+func desenharEtiqueta(pdf *fpdf.Fpdf, tr func(string) string, e Etiqueta, x, y, largura, altura float64) {
+	margem := 2.0
+	if altura < 30 {
+		margem = 1.5
+	}
+	lado := altura - 2*margem
+	if lado > largura/2.5 {
+		lado = largura / 2.5
+	}
+
+	// QR code, drawn as vector rectangles so it prints sharp
+	if bc, err := gerarCodigo(e.Conteudo, "qr"); err == nil {
+		m := modulosCodigo(bc)
+		modulo := lado / float64(m.Largura)
+		pdf.SetFillColor(0, 0, 0)
+		for my := 0; my < m.Altura; my++ {
+			for mx := 0; mx < m.Largura; {
+				if !m.Escuro(mx, my) {
+					mx++
+					continue
+				}
+				inicio := mx
+				for mx < m.Largura && m.Escuro(mx, my) {
+					mx++
+				}
+				pdf.Rect(x+margem+float64(inicio)*modulo, y+margem+float64(my)*modulo, float64(mx-inicio)*modulo, modulo, "F")
+			}
+		}
+	}
+	textoX := x + margem + lado + 1.5
+	textoLargura := x + largura - margem - textoX
+
+	// Wide labels also get the item thumbnail on the right
+	if e.Foto != "" && textoLargura >= 45 {
+		miniatura := math.Min(lado, textoLargura*0.4)
+		if registrarMiniatura(pdf, e.Foto) {
+			pdf.ImageOptions(e.Foto, x+largura-margem-miniatura, y+margem, miniatura, miniatura, false, fpdf.ImageOptions{}, 0, "")
+			textoLargura -= miniatura + 1.5
+		}
+	}
+
+	tamanho := altura / 3.8
+	if tamanho > 14 {
+		tamanho = 14
+	}
+	if tamanho < 6 {
+		tamanho = 6
+	}
+	linha := tamanho * 0.42 // mm per line at this font size
+
+	pdf.SetXY(textoX, y+margem)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont("Helvetica", "B", tamanho)
+	maxLinhas := 2
+	if e.Local == "" {
+		maxLinhas = 3
+	}
+	for _, l := range linhasLimitadas(pdf, tr(e.Titulo), textoLargura, maxLinhas) {
+		pdf.SetX(textoX)
+		pdf.CellFormat(textoLargura, linha, l, "", 1, "L", false, 0, "")
+	}
+	if e.Local != "" {
+		pdf.SetFont("Helvetica", "", tamanho*0.8)
+		for _, l := range linhasLimitadas(pdf, tr(e.Local), textoLargura, 1) {
+			pdf.SetX(textoX)
+			pdf.CellFormat(textoLargura, linha*0.8, l, "", 1, "L", false, 0, "")
+		}
+	}
+	pdf.SetFont("Helvetica", "", tamanho*0.7)
+	pdf.SetTextColor(100, 100, 100)
+	pdf.SetXY(textoX, y+altura-margem-linha*0.7)
+	pdf.CellFormat(textoLargura, linha*0.7, tr(e.Codigo), "", 0, "L", false, 0, "")
+}
+
+// linhasLimitadas wraps the text to the width and cuts it at max lines,
+// ending with "..." when something was left out.
+func linhasLimitadas(pdf *fpdf.Fpdf, texto string, largura float64, max int) []string {
+	linhas := pdf.SplitText(texto, largura)
+	if len(linhas) <= max {
+		return linhas
+	}
+	linhas = linhas[:max]
+	ultima := linhas[max-1]
+	for ultima != "" && pdf.GetStringWidth(ultima+"...") > largura {
+		ultima = strings.TrimSpace(ultima[:len(ultima)-1])
+	}
+	linhas[max-1] = ultima + "..."
+	return linhas
+}
+
+// registrarMiniatura loads an item thumbnail into the PDF once. The image
+// type is sniffed, since older thumbnails do not always match their
+// extension.
+func registrarMiniatura(pdf *fpdf.Fpdf, foto string) bool {
+	if pdf.GetImageInfo(foto) != nil {
+		return true
+	}
+	rc, err := armazenamento.Abrir("thumbs/" + foto)
+	if err != nil {
+		return false
+	}
+	conteudo, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return false
+	}
+	tipos := map[string]string{"image/jpeg": "JPG", "image/png": "PNG", "image/gif": "GIF"}
+	tipo, ok := tipos[http.DetectContentType(conteudo)]
+	if !ok {
+		return false
+	}
+	info := pdf.RegisterImageOptionsReader(foto, fpdf.ImageOptions{ImageType: tipo}, bytes.NewReader(conteudo))
+	if info == nil || pdf.Err() {
+		// A broken thumbnail must not spoil the whole sheet
+		log.Printf("Skipping thumbnail %s on labels: %v", foto, pdf.Error())
+		pdf.ClearError()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var colunasCSV = []string{"id", "nome", "descricao", "prateleira", "estante", "compartimento", "categoria", "tags", "foto", "quantidade"}
+
+// cabecalhoExportacao is the header of the CSV and XLSX exports: the fixed
+// columns, one "campo:<key>" column per custom field, then the addresses of
+// the item page and its photos.
+func cabecalhoExportacao() []string {
+	cabecalho := append([]string{}, colunasCSV...)
+	for _, c := range dados.Campos {
+		cabecalho = append(cabecalho, "campo:"+c.Nome)
+	}
+	return append(cabecalho, "url", "foto_url", "fotos_urls")
+}
+
+// Spreadsheets run text starting with one of these as a formula
+const inicioFormula = "=+-@\t\r"
+
+// neutralizarFormula prefixes text a spreadsheet would take for a formula
+// with an apostrophe, so an item named "=HYPERLINK(...)" stays text.
+// restaurarFormula undoes it on import.
+func neutralizarFormula(s string) string {
+	if s != "" && strings.ContainsRune(inicioFormula, rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func restaurarFormula(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune(inicioFormula, rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// linhaExportacao returns the cells of an item in the order of
+// cabecalhoExportacao, numbers as ints.
+func linhaExportacao(item Item, base string) []any {
+	linha := []any{
+		item.ID,
+		item.Nome,
+		item.Descricao,
+		item.Prateleira,
+		item.Estante,
+		item.Compartimento,
+		item.Categoria,
+		strings.Join(item.Tags, ","),
+		item.Foto,
+		item.Quantidade,
+	}
+	for _, c := range dados.Campos {
+		linha = append(linha, item.Campos[c.Nome])
+	}
+	fotos := urlsFotos(item, base)
+	principal := ""
+	if len(fotos) > 0 {
+		principal = fotos[0]
+	}
+	return append(linha, base+"/item?id="+strconv.Itoa(item.ID), principal, strings.Join(fotos, " "))
+}
+
+// urlsFotos returns the addresses of the item photos, the primary one first.
+func urlsFotos(item Item, base string) []string {
+	fotos := item.Fotos
+	if len(fotos) == 0 && item.Foto != "" {
+		fotos = []string{item.Foto}
+	}
+	var urls []string
+	for _, f := range fotos {
+		urls = append(urls, base+"/static/photos/"+url.PathEscape(f))
+	}
+	return urls
+}
+
+// escreverItensCSV writes the columns of cabecalhoExportacao, one row per
+// item as it goes, with text safe from formula injection. The XLSX export
+// needs no such guard, as its text cells are never run as formulas.
+func escreverItensCSV(w io.Writer, itens []Item, base string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(cabecalhoExportacao())
+	linha := make([]string, len(cabecalhoExportacao()))
+	for _, item := range itens {
+		for i, v := range linhaExportacao(item, base) {
+			if n, ok := v.(int); ok {
+				linha[i] = strconv.Itoa(n)
+			} else {
+				linha[i] = neutralizarFormula(v.(string))
+			}
+		}
+		cw.Write(linha)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func escreverItensXLSX(w io.Writer, itens []Item, base string) error {
+	planilha, err := novaPlanilhaXLSX(w)
+	if err != nil {
+		return err
+	}
+	var cabecalho []any
+	for _, c := range cabecalhoExportacao() {
+		cabecalho = append(cabecalho, c)
+	}
+	planilha.Linha(cabecalho...)
+	for _, item := range itens {
+		if err := planilha.Linha(linhaExportacao(item, base)...); err != nil {
+			return err
+		}
+	}
+	return planilha.Fechar()
+}
+
+// ItemExportado is an item of the JSON export, with its location and the
+// addresses of its page and photos.
+type ItemExportado struct {
+	ID            int               `json:"id"`
+	Nome          string            `json:"nome"`
+	Descricao     string            `json:"descricao"`
+	Prateleira    string            `json:"prateleira"`
+	Estante       string            `json:"estante"`
+	Compartimento string            `json:"compartimento"`
+	Categoria     string            `json:"categoria"`
+	Tags          []string          `json:"tags"`
+	Quantidade    int               `json:"quantidade"`
+	Campos        map[string]string `json:"campos,omitempty"`
+	Modificado    time.Time         `json:"modificado,omitzero"`
+	URL           string            `json:"url"`
+	FotoURL       string            `json:"foto_url,omitempty"`
+	FotosURLs     []string          `json:"fotos_urls,omitempty"`
+}
+
+// escreverItensJSON writes {"exportado", "parametros", "total", "itens"},
+// encoding the items one at a time.
+func escreverItensJSON(w io.Writer, itens []Item, base, parametros string) error {
+	exportado, _ := json.Marshal(time.Now())
+	filtro, _ := json.Marshal(parametros)
+	if _, err := fmt.Fprintf(w, "{\"exportado\":%s,\"parametros\":%s,\"total\":%d,\"itens\":[\n", exportado, filtro, len(itens)); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for i, item := range itens {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		exportavel := ItemExportado{
+			ID:            item.ID,
+			Nome:          item.Nome,
+			Descricao:     item.Descricao,
+			Prateleira:    item.Prateleira,
+			Estante:       item.Estante,
+			Compartimento: item.Compartimento,
+			Categoria:     item.Categoria,
+			Tags:          item.Tags,
+			Quantidade:    item.Quantidade,
+			Campos:        item.Campos,
+			Modificado:    item.Modificado,
+			URL:           base + "/item?id=" + strconv.Itoa(item.ID),
+			FotosURLs:     urlsFotos(item, base),
+		}
+		if exportavel.Tags == nil {
+			exportavel.Tags = []string{}
+		}
+		if len(exportavel.FotosURLs) > 0 {
+			exportavel.FotoURL = exportavel.FotosURLs[0]
+		}
+		if err := enc.Encode(exportavel); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]}\n")
+	return err
+}
+
+// exportarSelecao downloads the selected items as CSV.
+func exportarSelecao(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	ids := idsSelecionados(r)
+
+	var itens []Item
+	for _, id := range ids {
+		if i, ok := buscarItem(id); ok {
+			itens = append(itens, dados.Itens[i])
+		}
+	}
+
+	registrarAuditoria(r, "export", ids, "")
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="items.csv"`)
+	escreverItensCSV(w, itens, urlBase(r))
+}
+
+// exportarLista downloads every item of the list as the URL leaves it,
+// searched, filtered and sorted like the page, as CSV, XLSX or JSON. The file
+// is written while the items are gone through, not built in memory first.
+// Like the export of selected items it is for admins only.
+func exportarLista(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	formato := q.Get("formato")
+	tipos := map[string]string{
+		"csv":  "text/csv; charset=utf-8",
+		"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"json": "application/json",
+	}
+	if tipos[formato] == "" {
+		http.Error(w, "Unknown format, use csv, xlsx or json", http.StatusBadRequest)
+		return
+	}
+
+	itens, _, err := itensDaBusca(strings.TrimSpace(q.Get("q")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filtros := filtrosLista(q)
+	filtrados := itens[:0]
+	for i := range itens {
+		passa := true
+		for _, f := range filtros {
+			if passa = f.Teste(&itens[i]); !passa {
+				break
+			}
+		}
+		if passa {
+			filtrados = append(filtrados, itens[i])
+		}
+	}
+	ordenarItens(filtrados, ordemDaLista(r))
+
+	parametros := estadoLista(q).Encode()
+	registrarAuditoria(r, "export", nil, fmt.Sprintf("%s, %d item(s) %s", formato, len(filtrados), parametros))
+
+	w.Header().Set("Content-Type", tipos[formato])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="items-%s.%s"`, time.Now().Format("2006-01-02"), formato))
+	base := urlBase(r)
+	switch formato {
+	case "csv":
+		err = escreverItensCSV(w, filtrados, base)
+	case "xlsx":
+		err = escreverItensXLSX(w, filtrados, base)
+	case "json":
+		err = escreverItensJSON(w, filtrados, base, parametros)
+	}
+	if err != nil {
+		log.Printf("Error exporting items: %v", err)
+	}
+}
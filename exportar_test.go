@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNeutralizarFormula(t *testing.T) {
+	testes := []struct {
+		texto, exportado string
+	}{
+		{"", ""},
+		{"M4 screw", "M4 screw"},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+1", "'+1"},
+		{"-10mm spacer", "'-10mm spacer"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"\rcmd", "'\rcmd"},
+		{"'quoted", "'quoted"},
+		{"a=b", "a=b"},
+	}
+	for _, tt := range testes {
+		if got := neutralizarFormula(tt.texto); got != tt.exportado {
+			t.Errorf("neutralizarFormula(%q) = %q, want %q", tt.texto, got, tt.exportado)
+		}
+		if got := restaurarFormula(tt.exportado); got != tt.texto {
+			t.Errorf("restaurarFormula(%q) = %q, want %q", tt.exportado, got, tt.texto)
+		}
+	}
+}
+
+func TestEscreverItensCSVFormula(t *testing.T) {
+	anterior := dados
+	t.Cleanup(func() { dados = anterior })
+	dados = Inventario{}
+
+	var buf bytes.Buffer
+	err := escreverItensCSV(&buf, []Item{{ID: 7, Nome: "=1+2", Descricao: "@cmd", Prateleira: "P-0001", Quantidade: -1}}, "http://x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	linhas, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	linha := linhas[1]
+	if linha[0] != "7" || linha[1] != "'=1+2" || linha[2] != "'@cmd" || linha[3] != "P-0001" || linha[9] != "-1" {
+		t.Errorf("got row %q", linha)
+	}
+}
+
+// itensExportacao are the items of the XLSX and JSON tests: one with every
+// column filled and one with nothing but its place.
+func itensExportacao(t *testing.T) []Item {
+	usarDados(t, Inventario{Campos: []CampoPersonalizado{{Nome: "material", Rotulo: "Material", Tipo: "text"}}})
+	return []Item{
+		{
+			ID: 7, Nome: "-10mm spacer", Descricao: "a < b & c", Prateleira: "P-0001", Estante: "L1", Compartimento: "3",
+			Categoria: "Fixacao", Tags: []string{"m4", "fastener"}, Foto: "a b.jpg", Fotos: []string{"a b.jpg", "c.jpg"},
+			Quantidade: 12, Campos: map[string]string{"material": "steel"},
+			Modificado: time.Date(2026, 10, 18, 9, 12, 0, 0, time.UTC),
+		},
+		{ID: 8, Nome: "Lixa", Prateleira: "P-0002", Estante: "L2", Compartimento: "1"},
+	}
+}
+
+type celulaXLSX struct {
+	Ref    string `xml:"r,attr"`
+	Tipo   string `xml:"t,attr"`
+	Estilo string `xml:"s,attr"`
+	Valor  string `xml:"v"`
+	Texto  string `xml:"is>t"`
+}
+
+type folhaXLSX struct {
+	Linhas []struct {
+		Ref     string       `xml:"r,attr"`
+		Celulas []celulaXLSX `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func TestEscreverItensXLSX(t *testing.T) {
+	itens := itensExportacao(t)
+	var buf bytes.Buffer
+	if err := escreverItensXLSX(&buf, itens, "http://x"); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var partes []string
+	var folha folhaXLSX
+	for _, f := range z.File {
+		partes = append(partes, f.Name)
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conteudo, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every part must be well-formed XML
+		var qualquer struct{}
+		if err := xml.Unmarshal(conteudo, &qualquer); err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			if err := xml.Unmarshal(conteudo, &folha); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	sort.Strings(partes)
+	esperadas := []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/workbook.xml", "xl/worksheets/sheet1.xml"}
+	if !reflect.DeepEqual(partes, esperadas) {
+		t.Errorf("parts %v, want %v", partes, esperadas)
+	}
+
+	if len(folha.Linhas) != 3 {
+		t.Fatalf("got %d rows, want 3", len(folha.Linhas))
+	}
+	cabecalho := folha.Linhas[0]
+	if cabecalho.Ref != "1" || len(cabecalho.Celulas) != 14 {
+		t.Fatalf("header row %q has %d cells, want 14", cabecalho.Ref, len(cabecalho.Celulas))
+	}
+	for i, c := range cabecalho.Celulas {
+		if c.Ref != colunaXLSX(i)+"1" || c.Tipo != "inlineStr" || c.Estilo != "1" || c.Texto != cabecalhoExportacao()[i] {
+			t.Errorf("header cell %d = %+v", i, c)
+		}
+	}
+
+	// Empty text is left out, numbers have no type and text is kept as
+	// it is, formula-like or not
+	testes := []struct {
+		linha int
+		cel   []celulaXLSX
+	}{
+		{1, []celulaXLSX{
+			{Ref: "A2", Valor: "7"},
+			{Ref: "B2", Tipo: "inlineStr", Texto: "-10mm spacer"},
+			{Ref: "C2", Tipo: "inlineStr", Texto: "a < b & c"},
+			{Ref: "D2", Tipo: "inlineStr", Texto: "P-0001"},
+			{Ref: "E2", Tipo: "inlineStr", Texto: "L1"},
+			{Ref: "F2", Tipo: "inlineStr", Texto: "3"},
+			{Ref: "G2", Tipo: "inlineStr", Texto: "Fixacao"},
+			{Ref: "H2", Tipo: "inlineStr", Texto: "m4,fastener"},
+			{Ref: "I2", Tipo: "inlineStr", Texto: "a b.jpg"},
+			{Ref: "J2", Valor: "12"},
+			{Ref: "K2", Tipo: "inlineStr", Texto: "steel"},
+			{Ref: "L2", Tipo: "inlineStr", Texto: "http://x/item?id=7"},
+			{Ref: "M2", Tipo: "inlineStr", Texto: "http://x/static/photos/a%20b.jpg"},
+			{Ref: "N2", Tipo: "inlineStr", Texto: "http://x/static/photos/a%20b.jpg http://x/static/photos/c.jpg"},
+		}},
+		{2, []celulaXLSX{
+			{Ref: "A3", Valor: "8"},
+			{Ref: "B3", Tipo: "inlineStr", Texto: "Lixa"},
+			{Ref: "D3", Tipo: "inlineStr", Texto: "P-0002"},
+			{Ref: "E3", Tipo: "inlineStr", Texto: "L2"},
+			{Ref: "F3", Tipo: "inlineStr", Texto: "1"},
+			{Ref: "J3", Valor: "0"},
+			{Ref: "L3", Tipo: "inlineStr", Texto: "http://x/item?id=8"},
+		}},
+	}
+	for _, tt := range testes {
+		if got := folha.Linhas[tt.linha].Celulas; !reflect.DeepEqual(got, tt.cel) {
+			t.Errorf("row %d\n got %+v\nwant %+v", tt.linha+1, got, tt.cel)
+		}
+	}
+}
+
+func TestColunaXLSX(t *testing.T) {
+	testes := []struct {
+		indice int
+		nome   string
+	}{
+		{0, "A"}, {25, "Z"}, {26, "AA"}, {27, "AB"}, {51, "AZ"}, {52, "BA"}, {701, "ZZ"}, {702, "AAA"},
+	}
+	for _, tt := range testes {
+		if got := colunaXLSX(tt.indice); got != tt.nome {
+			t.Errorf("colunaXLSX(%d) = %q, want %q", tt.indice, got, tt.nome)
+		}
+	}
+}
+
+// The JSON export has the format given in the README.
+func TestEscreverItensJSON(t *testing.T) {
+	itens := itensExportacao(t)
+	var buf bytes.Buffer
+	antes := time.Now().Truncate(time.Second)
+	if err := escreverItensJSON(&buf, itens, "http://x", "estante=L1&q=m4"); err != nil {
+		t.Fatal(err)
+	}
+
+	var saida struct {
+		Exportado  time.Time        `json:"exportado"`
+		Parametros string           `json:"parametros"`
+		Total      int              `json:"total"`
+		Itens      []map[string]any `json:"itens"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&saida); err != nil {
+		t.Fatalf("%v in %s", err, buf.String())
+	}
+	if saida.Exportado.Before(antes) || saida.Parametros != "estante=L1&q=m4" || saida.Total != 2 || len(saida.Itens) != 2 {
+		t.Fatalf("got %s", buf.String())
+	}
+
+	// Decoded as plain JSON values, so a field written under another name
+	// or type shows up
+	testes := []map[string]any{
+		{
+			"id":            7.0,
+			"nome":          "-10mm spacer",
+			"descricao":     "a < b & c",
+			"prateleira":    "P-0001",
+			"estante":       "L1",
+			"compartimento": "3",
+			"categoria":     "Fixacao",
+			"tags":          []any{"m4", "fastener"},
+			"quantidade":    12.0,
+			"campos":        map[string]any{"material": "steel"},
+			"modificado":    "2026-10-18T09:12:00Z",
+			"url":           "http://x/item?id=7",
+			"foto_url":      "http://x/static/photos/a%20b.jpg",
+			"fotos_urls":    []any{"http://x/static/photos/a%20b.jpg", "http://x/static/photos/c.jpg"},
+		},
+		{
+			"id":            8.0,
+			"nome":          "Lixa",
+			"descricao":     "",
+			"prateleira":    "P-0002",
+			"estante":       "L2",
+			"compartimento": "1",
+			"categoria":     "",
+			"tags":          []any{},
+			"quantidade":    0.0,
+			"url":           "http://x/item?id=8",
+		},
+	}
+	for i, esperado := range testes {
+		if !reflect.DeepEqual(saida.Itens[i], esperado) {
+			t.Errorf("item %d\n got %v\nwant %v", i, saida.Itens[i], esperado)
+		}
+	}
+}
+
+func TestEscreverItensJSONVazio(t *testing.T) {
+	var buf bytes.Buffer
+	if err := escreverItensJSON(&buf, nil, "http://x", ""); err != nil {
+		t.Fatal(err)
+	}
+	var saida map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &saida); err != nil {
+		t.Fatalf("%v in %s", err, buf.String())
+	}
+	if saida["total"] != 0.0 || !reflect.DeepEqual(saida["itens"], []any{}) {
+		t.Errorf("got %s", buf.String())
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"sort"
+)
+
+// How many values each facet lists, the most common first
+const maxValoresFaceta = 8
+
+// filtroLista is one of the filters of the item list, set by a URL parameter.
+type filtroLista struct {
+	Param string
+	Teste func(*Item) bool
+}
+
+// filtrosLista returns the filters set in the item list URL.
+func filtrosLista(q url.Values) []filtroLista {
+	var filtros []filtroLista
+	if categoria := q.Get("categoria"); categoria != "" {
+		// A category includes every subcategory
+		categorias := categoriaEDescendentes(categoria)
+		filtros = append(filtros, filtroLista{"categoria", func(item *Item) bool { return categorias[item.Categoria] }})
+	}
+	if tag := normalizarTag(q.Get("tag")); tag != "" {
+		filtros = append(filtros, filtroLista{"tag", func(item *Item) bool { return contem(item.Tags, tag) }})
+	}
+	if estante := q.Get("estante"); estante != "" {
+		filtros = append(filtros, filtroLista{"estante", func(item *Item) bool { return item.Estante == estante }})
+	}
+	if rack := q.Get("rack"); rack != "" {
+		filtros = append(filtros, filtroLista{"rack", func(item *Item) bool { return item.Prateleira == rack }})
+	}
+	switch q.Get("foto") {
+	case "sim":
+		filtros = append(filtros, filtroLista{"foto", func(item *Item) bool { return item.Foto != "" }})
+	case "nao":
+		filtros = append(filtros, filtroLista{"foto", func(item *Item) bool { return item.Foto == "" }})
+	}
+	if q.Get("estoque") == "baixo" {
+		filtros = append(filtros, filtroLista{"estoque", func(item *Item) bool { return estoqueBaixo(*item) }})
+	}
+	return filtros
+}
+
+// dimensaoFaceta is something the item list is broken down by: Valores
+// calls contar with each value an item counts towards, and Rotulo shows a
+// value.
+type dimensaoFaceta struct {
+	Param   string
+	Nome    string
+	Valores func(item *Item, contar func(string))
+	Rotulo  func(string) string
+}
+
+func dimensoesFaceta() []dimensaoFaceta {
+	// An item also counts towards the parents of its category, since
+	// filtering by a category includes its subcategories
+	pais := make(map[string]string)
+	for _, c := range dados.Categorias {
+		pais[c.Nome] = c.Pai
+	}
+	categorias := func(item *Item, contar func(string)) {
+		// The depth limit guards against a loop in the category tree
+		for c, n := item.Categoria, 0; c != "" && n <= len(pais); c, n = pais[c], n+1 {
+			contar(c)
+		}
+	}
+
+	mesmo := func(v string) string { return v }
+	return []dimensaoFaceta{
+		{"estante", "Shelf", func(item *Item, contar func(string)) { contar(item.Estante) }, mesmo},
+		{"rack", "Rack", func(item *Item, contar func(string)) { contar(item.Prateleira) }, mesmo},
+		{"categoria", "Category", categorias, mesmo},
+		{"foto", "Photo", func(item *Item, contar func(string)) {
+			if item.Foto != "" {
+				contar("sim")
+			} else {
+				contar("nao")
+			}
+		}, func(v string) string {
+			if v == "sim" {
+				return "With photo"
+			}
+			return "Without photo"
+		}},
+		{"estoque", "Stock", func(item *Item, contar func(string)) {
+			if estoqueBaixo(*item) {
+				contar("baixo")
+			}
+		}, func(string) string { return fmt.Sprintf("Low stock (%d or less)", limiteEstoqueBaixo()) }},
+	}
+}
+
+// agregarFacetas applies the filters to the items and, in the same single
+// pass, counts the values of each facet over the items that every other
+// filter lets through. That way a facet shows what picking another of its
+// values would leave, not just the value already picked.
+func agregarFacetas(itens []Item, filtros []filtroLista, dimensoes []dimensaoFaceta) ([]Item, []map[string]int) {
+	contagens := make([]map[string]int, len(dimensoes))
+	for d := range contagens {
+		contagens[d] = make(map[string]int)
+	}
+	// Facet of each filter, or -1 for filters without one
+	dimensaoDoFiltro := make([]int, len(filtros))
+	for f, filtro := range filtros {
+		dimensaoDoFiltro[f] = -1
+		for d, dim := range dimensoes {
+			if dim.Param == filtro.Param {
+				dimensaoDoFiltro[f] = d
+			}
+		}
+	}
+	contadores := make([]func(string), len(dimensoes))
+	for d := range dimensoes {
+		contadores[d] = func(v string) {
+			if v != "" {
+				contagens[d][v]++
+			}
+		}
+	}
+	contar := func(d int, item *Item) {
+		dimensoes[d].Valores(item, contadores[d])
+	}
+
+	var resultado []Item
+	for i := range itens {
+		item := &itens[i]
+		falhas, falhou := 0, -1
+		for f, filtro := range filtros {
+			if !filtro.Teste(item) {
+				falhas++
+				falhou = f
+				if falhas > 1 {
+					break
+				}
+			}
+		}
+		switch {
+		case falhas == 0:
+			resultado = append(resultado, *item)
+			for d := range dimensoes {
+				contar(d, item)
+			}
+		case falhas == 1 && dimensaoDoFiltro[falhou] >= 0:
+			contar(dimensaoDoFiltro[falhou], item)
+		}
+	}
+	return resultado, contagens
+}
+
+// ValorFaceta is one value of a facet, URL being the item list narrowed to
+// it, or widened again when it is the active one.
+type ValorFaceta struct {
+	Valor      string       `json:"valor"`
+	Rotulo     string       `json:"rotulo"`
+	Quantidade int          `json:"quantidade"`
+	Ativo      bool         `json:"ativo,omitempty"`
+	URL        template.URL `json:"-"`
+}
+
+type Faceta struct {
+	Param   string        `json:"param"`
+	Nome    string        `json:"nome"`
+	Valores []ValorFaceta `json:"valores"`
+	Mais    int           `json:"mais,omitempty"` // values left out of the list
+}
+
+// montarFacetas turns the counts into the facets shown next to the results,
+// with links that keep the rest of the list state.
+func montarFacetas(dimensoes []dimensaoFaceta, contagens []map[string]int, estado url.Values) []Faceta {
+	var facetas []Faceta
+	for d, dim := range dimensoes {
+		ativo := estado.Get(dim.Param)
+		var valores []ValorFaceta
+		for v, n := range contagens[d] {
+			valores = append(valores, ValorFaceta{Valor: v, Rotulo: dim.Rotulo(v), Quantidade: n, Ativo: v == ativo})
+		}
+		if len(valores) == 0 {
+			continue
+		}
+		sort.Slice(valores, func(i, j int) bool {
+			if valores[i].Ativo != valores[j].Ativo {
+				return valores[i].Ativo
+			}
+			if valores[i].Quantidade != valores[j].Quantidade {
+				return valores[i].Quantidade > valores[j].Quantidade
+			}
+			return compararNatural(valores[i].Valor, valores[j].Valor) < 0
+		})
+
+		faceta := Faceta{Param: dim.Param, Nome: dim.Nome}
+		if len(valores) > maxValoresFaceta {
+			faceta.Mais = len(valores) - maxValoresFaceta
+			valores = valores[:maxValoresFaceta]
+		}
+		for i := range valores {
+			link := url.Values{}
+			for k, v := range estado {
+				link[k] = v
+			}
+			if valores[i].Ativo {
+				link.Del(dim.Param)
+			} else {
+				link.Set(dim.Param, valores[i].Valor)
+			}
+			valores[i].URL = template.URL("/?" + link.Encode())
+		}
+		faceta.Valores = valores
+		facetas = append(facetas, faceta)
+	}
+	return facetas
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// usarDados swaps the inventory for the duration of a test.
+func usarDados(t *testing.T, inventario Inventario) {
+	t.Helper()
+	anterior := dados
+	dados = inventario
+	reconstruirIndice()
+	t.Cleanup(func() {
+		dados = anterior
+		reconstruirIndice()
+	})
+}
+
+func TestAgregarFacetas(t *testing.T) {
+	usarDados(t, Inventario{
+		Categorias: []Categoria{
+			{Nome: "Fixacao"},
+			{Nome: "Parafusos", Pai: "Fixacao"},
+			{Nome: "Ferramentas"},
+		},
+		Itens: []Item{
+			{ID: 1, Estante: "L1", Prateleira: "P-0001", Categoria: "Parafusos", Foto: "a.jpg", Quantidade: 10, Tags: []string{"m4"}},
+			{ID: 2, Estante: "L1", Prateleira: "P-0002", Categoria: "Fixacao", Quantidade: 1},
+			{ID: 3, Estante: "L2", Prateleira: "P-0001", Categoria: "Ferramentas", Foto: "b.jpg", Quantidade: 0},
+			{ID: 4, Estante: "L2", Prateleira: "P-0002", Quantidade: 3, Tags: []string{"m4"}},
+			{ID: 5, Estante: "L1", Prateleira: "P-0001", Categoria: "Parafusos", Quantidade: 1},
+		},
+	})
+	anterior := config.LowStockThreshold
+	config.LowStockThreshold = 0 // one unit or less is low
+	t.Cleanup(func() { config.LowStockThreshold = anterior })
+
+	testes := []struct {
+		nome     string
+		consulta string
+		ids      []int
+		// estante, rack, categoria, foto and estoque, each counted over the
+		// items every other filter lets through
+		contagens []map[string]int
+	}{
+		{
+			"no filters", "",
+			[]int{1, 2, 3, 4, 5},
+			[]map[string]int{
+				{"L1": 3, "L2": 2},
+				{"P-0001": 3, "P-0002": 2},
+				{"Fixacao": 3, "Parafusos": 2, "Ferramentas": 1},
+				{"sim": 2, "nao": 3},
+				{"baixo": 3},
+			},
+		},
+		{
+			"shelf keeps counting the other shelves", "estante=L1",
+			[]int{1, 2, 5},
+			[]map[string]int{
+				{"L1": 3, "L2": 2},
+				{"P-0001": 2, "P-0002": 1},
+				{"Fixacao": 3, "Parafusos": 2},
+				{"sim": 1, "nao": 2},
+				{"baixo": 2},
+			},
+		},
+		{
+			"shelf and rack count each other", "estante=L1&rack=P-0001",
+			[]int{1, 5},
+			[]map[string]int{
+				{"L1": 2, "L2": 1},
+				{"P-0001": 2, "P-0002": 1},
+				{"Fixacao": 2, "Parafusos": 2},
+				{"sim": 1, "nao": 1},
+				{"baixo": 1},
+			},
+		},
+		{
+			"category includes subcategories", "categoria=Fixacao",
+			[]int{1, 2, 5},
+			[]map[string]int{
+				{"L1": 3},
+				{"P-0001": 2, "P-0002": 1},
+				{"Fixacao": 3, "Parafusos": 2, "Ferramentas": 1},
+				{"sim": 1, "nao": 2},
+				{"baixo": 2},
+			},
+		},
+		{
+			"low stock with photo", "estoque=baixo&foto=sim",
+			[]int{3},
+			[]map[string]int{
+				{"L2": 1},
+				{"P-0001": 1},
+				{"Ferramentas": 1},
+				{"sim": 1, "nao": 2},
+				{"baixo": 1},
+			},
+		},
+		{
+			"filter without a facet", "tag=+M4+",
+			[]int{1, 4},
+			[]map[string]int{
+				{"L1": 1, "L2": 1},
+				{"P-0001": 1, "P-0002": 1},
+				{"Fixacao": 1, "Parafusos": 1},
+				{"sim": 1, "nao": 1},
+				{},
+			},
+		},
+		{
+			"nothing left", "estante=L3",
+			nil,
+			[]map[string]int{
+				{"L1": 3, "L2": 2},
+				{},
+				{},
+				{},
+				{},
+			},
+		},
+	}
+	for _, tt := range testes {
+		t.Run(tt.nome, func(t *testing.T) {
+			q, _ := url.ParseQuery(tt.consulta)
+			itens := append([]Item(nil), dados.Itens...)
+			resultado, got := agregarFacetas(itens, filtrosLista(q), dimensoesFaceta())
+			var ids []int
+			for _, item := range resultado {
+				ids = append(ids, item.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("items %v, want %v", ids, tt.ids)
+			}
+			if !reflect.DeepEqual(got, tt.contagens) {
+				t.Errorf("counts\n got %v\nwant %v", got, tt.contagens)
+			}
+		})
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Anexo is a non-image file attached to an item, such as a datasheet or an
+// STL model. Arquivo is the name on disk and Nome the original filename.
+type Anexo struct {
+	Arquivo string `json:"arquivo"`
+	Nome    string `json:"nome"`
+	Tipo    string `json:"tipo"`
+	Tamanho int64  `json:"tamanho"`
+}
+
+const (
+	// Attachments stay out of static/, which is served without login, and
+	// are only downloaded through baixarAnexo
+	pastaAnexos       = "data/anexos"
+	pastaAnexosAntiga = "static/anexos"
+	pastaFotos        = "static/photos" // root of the local photo store
+)
+
+// Content types accepted for attachments, as reported by
+// http.DetectContentType, and the extensions they may be stored under.
+var (
+	tiposAnexo = map[string]bool{
+		"application/pdf":          true,
+		"application/zip":          true,
+		"application/x-gzip":       true,
+		"application/octet-stream": true,
+		"text/plain":               true,
+	}
+	extensoesAnexo = map[string]bool{
+		".pdf": true, ".txt": true, ".md": true, ".csv": true,
+		".stl": true, ".3mf": true, ".obj": true, ".step": true, ".stp": true, ".dxf": true,
+		".gcode": true, ".zip": true, ".gz": true,
+	}
+)
+
+// moverAnexosAntigos moves the attachments left in the old public folder
+// into pastaAnexos at startup.
+func moverAnexosAntigos() {
+	arquivos, err := os.ReadDir(pastaAnexosAntiga)
+	if err != nil {
+		return
+	}
+	os.MkdirAll(pastaAnexos, 0755)
+	movidos := 0
+	for _, a := range arquivos {
+		if a.IsDir() {
+			continue
+		}
+		if err := moverArquivo(filepath.Join(pastaAnexosAntiga, a.Name()), filepath.Join(pastaAnexos, a.Name())); err != nil {
+			log.Printf("Error moving attachment %s: %v", a.Name(), err)
+			continue
+		}
+		movidos++
+	}
+	os.Remove(pastaAnexosAntiga)
+	if movidos > 0 {
+		log.Printf("Moved %d attachment(s) from %s to %s", movidos, pastaAnexosAntiga, pastaAnexos)
+	}
+}
+
+// moverArquivo renames a file, copying it when both paths are on different
+// volumes.
+func moverArquivo(origem, destino string) error {
+	if os.Rename(origem, destino) == nil {
+		return nil
+	}
+	entrada, err := os.Open(origem)
+	if err != nil {
+		return err
+	}
+	defer entrada.Close()
+	saida, err := os.Create(destino)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(saida, entrada); err != nil {
+		saida.Close()
+		os.Remove(destino)
+		return err
+	}
+	if err := saida.Close(); err != nil {
+		os.Remove(destino)
+		return err
+	}
+	return os.Remove(origem)
+}
+
+func limiteAnexo() int64 {
+	mb := config.MaxAttachmentMB
+	if mb <= 0 {
+		mb = 20
+	}
+	return int64(mb) << 20
+}
+
+// atualizarFotoPrincipal keeps Foto pointing at the first photo of the
+// gallery, which is what lists and thumbnails show.
+func atualizarFotoPrincipal(item *Item) {
+	if len(item.Fotos) > 0 {
+		item.Foto = item.Fotos[0]
+	} else {
+		item.Foto = ""
+	}
+}
+
+// migrarFotos fills the gallery of items saved before it existed.
+func migrarFotos() {
+	for i, item := range dados.Itens {
+		if item.Foto != "" && len(item.Fotos) == 0 {
+			dados.Itens[i].Fotos = []string{item.Foto}
+		}
+	}
+}
+
+// removerFoto deletes a photo and its renditions once nothing references it
+// anymore. Callers drop their own reference first.
+func removerFoto(filename string) {
+	if filename == "" || fotoEmUso(filename) {
+		return
+	}
+	for _, nome := range []string{filename, "thumbs/" + filename, "previews/" + filename} {
+		if err := armazenamento.Remover(nome); err != nil {
+			log.Printf("Error removing photo %s: %v", nome, err)
+		}
+	}
+}
+
+// removerArquivosItem deletes the attachments of a deleted item and the
+// photos no other item or user shares.
+func removerArquivosItem(item Item) {
+	for _, foto := range item.Fotos {
+		removerFoto(foto)
+	}
+	for _, a := range item.Anexos {
+		os.Remove(filepath.Join(pastaAnexos, a.Arquivo))
+	}
+}
+
+// salvarVersoes writes the thumbnail and the preview rendition of a photo.
+// Both keep the filename, and so the format, of the original.
+func salvarVersoes(img image.Image, filename string) error {
+	versoes := []struct {
+		pasta string
+		img   image.Image
+	}{
+		{"thumbs/", generateThumbnail(img)},
+		{"previews/", generatePreview(img)},
+	}
+	for _, v := range versoes {
+		var buf bytes.Buffer
+		if err := codificarImagem(&buf, v.img, formatoArquivo(filename)); err != nil {
+			return err
+		}
+		if err := armazenamento.Salvar(v.pasta+filename, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// regenerarVersoes rebuilds the thumbnail and preview of every stored photo,
+// e.g. after the sizes in config.json change. It returns how many photos
+// were processed.
+func regenerarVersoes() (int, error) {
+	objetos, err := armazenamento.Listar()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, o := range objetos {
+		if strings.Contains(o.Nome, "/") {
+			// Renditions live in subfolders
+			continue
+		}
+		f, err := armazenamento.Abrir(o.Nome)
+		if err != nil {
+			return total, err
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			log.Printf("Skipping %s: %v", o.Nome, err)
+			continue
+		}
+		if err := salvarVersoes(img, o.Nome); err != nil {
+			return total, err
+		}
+		total++
+	}
+	return total, nil
+}
+
+// salvarFotosDoForm saves every image uploaded in the given form field,
+// plus the camera capture sent in <campo>_camera, and returns the stored
+// filenames. On the first image that cannot be stored the ones already
+// saved are removed and the error returned.
+func salvarFotosDoForm(r *http.Request, campo string) ([]string, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	var fotos []string
+	salvar := func(header *multipart.FileHeader, recorte Recorte) error {
+		file, err := header.Open()
+		if err == nil {
+			var filename string
+			filename, err = saveImageRecortada(file, recorte)
+			file.Close()
+			if err == nil && !contem(fotos, filename) {
+				fotos = append(fotos, filename)
+			}
+		}
+		if err != nil {
+			log.Printf("Error saving image %s: %v", header.Filename, err)
+			for _, f := range fotos {
+				removerFoto(f)
+			}
+			return fmt.Errorf("%s: %v", header.Filename, err)
+		}
+		return nil
+	}
+	// A photo taken with the camera comes first, cropped as the user chose
+	for _, header := range r.MultipartForm.File[campo+"_camera"] {
+		if err := salvar(header, recorteDoForm(r)); err != nil {
+			return nil, err
+		}
+	}
+	for _, header := range r.MultipartForm.File[campo] {
+		if err := salvar(header, Recorte{}); err != nil {
+			return nil, err
+		}
+	}
+	return fotos, nil
+}
+
+// salvarAnexo stores an uploaded attachment after checking its size, its
+// extension and its sniffed content type.
+func salvarAnexo(file multipart.File, header *multipart.FileHeader) (Anexo, error) {
+	limite := limiteAnexo()
+	if header.Size > limite {
+		return Anexo{}, fmt.Errorf("%s is larger than %d MB", header.Filename, limite>>20)
+	}
+
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if !extensoesAnexo[ext] {
+		return Anexo{}, fmt.Errorf("%s: file type %q is not allowed", header.Filename, ext)
+	}
+
+	inicio := make([]byte, 512)
+	n, err := io.ReadFull(file, inicio)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Anexo{}, err
+	}
+	inicio = inicio[:n]
+	tipo, _, _ := mime.ParseMediaType(http.DetectContentType(inicio))
+	if !tiposAnexo[tipo] {
+		return Anexo{}, fmt.Errorf("%s: content type %s is not allowed", header.Filename, tipo)
+	}
+
+	os.MkdirAll(pastaAnexos, 0755)
+	arquivo := fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
+	f, err := os.Create(filepath.Join(pastaAnexos, arquivo))
+	if err != nil {
+		return Anexo{}, err
+	}
+	defer f.Close()
+
+	tamanho, err := io.Copy(f, io.LimitReader(io.MultiReader(bytes.NewReader(inicio), file), limite+1))
+	if err == nil && tamanho > limite {
+		err = fmt.Errorf("%s is larger than %d MB", header.Filename, limite>>20)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(filepath.Join(pastaAnexos, arquivo))
+		return Anexo{}, err
+	}
+
+	return Anexo{
+		Arquivo: arquivo,
+		Nome:    filepath.Base(header.Filename),
+		Tipo:    tipo,
+		Tamanho: tamanho,
+	}, nil
+}
+
+// salvarAnexosDoForm stores every attachment of the form field. On the first
+// rejected file the ones already stored are removed and the error returned.
+func salvarAnexosDoForm(r *http.Request, campo string) ([]Anexo, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	var anexos []Anexo
+	for _, header := range r.MultipartForm.File[campo] {
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		anexo, err := salvarAnexo(file, header)
+		file.Close()
+		if err != nil {
+			for _, a := range anexos {
+				os.Remove(filepath.Join(pastaAnexos, a.Arquivo))
+			}
+			return nil, err
+		}
+		anexos = append(anexos, anexo)
+	}
+	return anexos, nil
+}
+
+func tamanhoLegivel(bytes int64) string {
+	switch {
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
+
+func verItem(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	i, ok := buscarItem(id)
+	if !ok {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	item := dados.Itens[i]
+
+	tmpl := template.Must(template.New("item.html").Funcs(template.FuncMap{
+		"tamanho": tamanhoLegivel,
+	}).ParseFiles("templates/item.html"))
+	tmpl.Execute(w, struct {
+		Item   Item
+		Campos []CampoForm
+		Config Config
+		Role   string
+	}{
+		Item:   item,
+		Campos: montarCamposForm(item),
+		Config: config,
+		Role:   getUserRole(r),
+	})
+}
+
+// organizarFotos reorders the gallery of an item or removes a photo from it.
+func organizarFotos(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	foto := r.FormValue("foto")
+
+	i, ok := buscarItem(id)
+	if !ok {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	item := &dados.Itens[i]
+
+	pos := -1
+	for j, f := range item.Fotos {
+		if f == foto {
+			pos = j
+			break
+		}
+	}
+	if pos < 0 {
+		http.Error(w, "Photo not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.FormValue("acao") {
+	case "principal":
+		item.Fotos = append([]string{foto}, append(item.Fotos[:pos:pos], item.Fotos[pos+1:]...)...)
+	case "subir":
+		if pos > 0 {
+			item.Fotos[pos-1], item.Fotos[pos] = item.Fotos[pos], item.Fotos[pos-1]
+		}
+	case "descer":
+		if pos < len(item.Fotos)-1 {
+			item.Fotos[pos+1], item.Fotos[pos] = item.Fotos[pos], item.Fotos[pos+1]
+		}
+	case "remover":
+		item.Fotos = append(item.Fotos[:pos], item.Fotos[pos+1:]...)
+		removerFoto(foto)
+	}
+	atualizarFotoPrincipal(item)
+	item.Modificado = time.Now()
+
+	salvarDados()
+	http.Redirect(w, r, "/editar?id="+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+// recortarFoto shows the crop tool for one of an item's photos and, on POST,
+// replaces the photo with the rotated and cropped version.
+func recortarFoto(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	foto := r.FormValue("foto")
+
+	i, ok := buscarItem(id)
+	if !ok {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	pos := -1
+	for j, f := range dados.Itens[i].Fotos {
+		if f == foto {
+			pos = j
+			break
+		}
+	}
+	if pos < 0 {
+		http.Error(w, "Photo not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		renderRecortarFoto(w, dados.Itens[i], foto, "")
+		return
+	}
+
+	rc, err := armazenamento.Abrir(foto)
+	if err != nil {
+		log.Printf("Error reading photo %s: %v", foto, err)
+		renderRecortarFoto(w, dados.Itens[i], foto, "The original photo could not be read")
+		return
+	}
+	nova, err := saveImageRecortada(rc, recorteDoForm(r))
+	rc.Close()
+	if err != nil {
+		log.Printf("Error cropping photo %s: %v", foto, err)
+		renderRecortarFoto(w, dados.Itens[i], foto, err.Error())
+		return
+	}
+
+	item := &dados.Itens[i]
+	if contem(item.Fotos, nova) {
+		// Nothing changed, or the result matches another photo of the item
+		if nova != foto {
+			item.Fotos = append(item.Fotos[:pos], item.Fotos[pos+1:]...)
+		}
+	} else {
+		item.Fotos[pos] = nova
+	}
+	atualizarFotoPrincipal(item)
+	item.Modificado = time.Now()
+	salvarDados()
+	if nova != foto {
+		removerFoto(foto)
+	}
+	http.Redirect(w, r, "/editar?id="+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+func renderRecortarFoto(w http.ResponseWriter, item Item, foto, erro string) {
+	tmpl := template.Must(template.ParseFiles("templates/recortar_foto.html", "templates/recorte.html"))
+	tmpl.Execute(w, struct {
+		Item  Item
+		Foto  string
+		Error string
+	}{item, foto, erro})
+}
+
+func baixarAnexo(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	arquivo := r.URL.Query().Get("arquivo")
+
+	if i, ok := buscarItem(id); ok {
+		for _, a := range dados.Itens[i].Anexos {
+			if a.Arquivo == arquivo {
+				w.Header().Set("Content-Type", a.Tipo)
+				w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Nome}))
+				w.Header().Set("X-Content-Type-Options", "nosniff")
+				http.ServeFile(w, r, filepath.Join(pastaAnexos, a.Arquivo))
+				return
+			}
+		}
+	}
+	http.Error(w, "Attachment not found", http.StatusNotFound)
+}
+
+func deletarAnexo(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	arquivo := r.FormValue("arquivo")
+
+	if i, ok := buscarItem(id); ok {
+		anexos := dados.Itens[i].Anexos
+		for j, a := range anexos {
+			if a.Arquivo == arquivo {
+				dados.Itens[i].Anexos = append(anexos[:j], anexos[j+1:]...)
+				dados.Itens[i].Modificado = time.Now()
+				os.Remove(filepath.Join(pastaAnexos, a.Arquivo))
+				salvarDados()
+				break
+			}
+		}
+	}
+	http.Redirect(w, r, "/editar?id="+strconv.Itoa(id), http.StatusSeeOther)
+}
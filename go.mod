@@ -1,11 +1,14 @@
 module inventario-oficina
 
-go 1.23.1
+go 1.24
 
 require (
+	github.com/boombuler/barcode v1.1.0
+	github.com/go-pdf/fpdf v0.9.0
 	github.com/gorilla/sessions v1.4.0
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	golang.org/x/crypto v0.37.0
+	golang.org/x/image v0.29.0
 )
 
 require github.com/gorilla/securecookie v1.1.2 // indirect
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	errHEIC          = errors.New("HEIC photos are not supported, please upload JPEG, PNG, GIF or WebP (on iPhone: Settings > Camera > Formats > Most Compatible)")
+	errFormatoImagem = errors.New("not a supported image, please upload JPEG, PNG, GIF or WebP")
+
+	// Content types, as sniffed by http.DetectContentType, accepted as photos
+	tiposImagem = map[string]bool{
+		"image/jpeg": true,
+		"image/png":  true,
+		"image/gif":  true,
+		"image/webp": true,
+	}
+)
+
+// decodificarFoto decodes an uploaded photo and returns it upright together
+// with the format it is stored in: "jpeg" for photos and "png" for images
+// with transparency. Since every photo is re-encoded, EXIF metadata such as
+// GPS coordinates never reaches the disk.
+func decodificarFoto(r io.Reader) (image.Image, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if ehHEIC(data) {
+		return nil, "", errHEIC
+	}
+	// Trust the content, not the filename or the browser
+	if !tiposImagem[http.DetectContentType(data)] {
+		return nil, "", errFormatoImagem
+	}
+
+	// Check the dimensions from the header before decoding, so a small file
+	// claiming a huge image cannot exhaust memory
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errFormatoImagem
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > limitePixels() {
+		return nil, "", fmt.Errorf("image is too large (%d×%d), the maximum is %d megapixels",
+			cfg.Width, cfg.Height, limitePixels()/1000000)
+	}
+
+	img, formato, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errFormatoImagem
+	}
+
+	switch formato {
+	case "jpeg":
+		return orientarImagem(img, orientacaoEXIF(data)), "jpeg", nil
+	case "webp":
+		if opaca(img) {
+			return img, "jpeg", nil
+		}
+	}
+	return img, "png", nil
+}
+
+func opaca(img image.Image) bool {
+	o, ok := img.(interface{ Opaque() bool })
+	return ok && o.Opaque()
+}
+
+// ehHEIC recognizes the ISO BMFF "ftyp" box used by HEIC/HEIF files.
+func ehHEIC(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	switch string(data[8:12]) {
+	case "heic", "heix", "hevc", "heim", "heis", "mif1", "msf1":
+		return true
+	}
+	return false
+}
+
+func extensaoFormato(formato string) string {
+	if formato == "png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// formatoArquivo returns the format a stored photo was written in, based on
+// the extension saveImage gave it.
+func formatoArquivo(filename string) string {
+	if strings.ToLower(filepath.Ext(filename)) == ".png" {
+		return "png"
+	}
+	return "jpeg"
+}
+
+func codificarImagem(w io.Writer, img image.Image, formato string) error {
+	if formato == "png" {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+}
+
+// orientacaoEXIF returns the EXIF orientation (1 to 8) of a JPEG file, or 1
+// when the file carries none.
+func orientacaoEXIF(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marcador := data[pos+1]
+		if marcador == 0xDA || marcador == 0xD9 {
+			// Start of the image data, no EXIF before it
+			return 1
+		}
+		tamanho := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if tamanho < 2 || pos+2+tamanho > len(data) {
+			return 1
+		}
+		segmento := data[pos+4 : pos+2+tamanho]
+		if marcador == 0xE1 && len(segmento) > 6 && string(segmento[:6]) == "Exif\x00\x00" {
+			return orientacaoTIFF(segmento[6:])
+		}
+		pos += 2 + tamanho
+	}
+	return 1
+}
+
+// orientacaoTIFF reads the Orientation tag (0x0112) from the first IFD of an
+// EXIF TIFF block.
+func orientacaoTIFF(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var ordem binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		ordem = binary.LittleEndian
+	case "MM":
+		ordem = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(ordem.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	n := int(ordem.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			break
+		}
+		if ordem.Uint16(tiff[e:]) == 0x0112 {
+			if o := int(ordem.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orientarImagem flips and rotates the image so that it displays upright
+// for the given EXIF orientation.
+func orientarImagem(img image.Image, orientacao int) image.Image {
+	if orientacao <= 1 || orientacao > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	// Orientations 5 to 8 swap width and height
+	dw, dh := w, h
+	if orientacao >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientacao {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			s := y*src.Stride + x*4
+			d := dy*dst.Stride + dx*4
+			copy(dst.Pix[d:d+4], src.Pix[s:s+4])
+		}
+	}
+	return dst
+}
+
+// Recorte is a rotation followed by a crop chosen in the browser. The crop
+// rectangle is given as fractions (0 to 1) of the rotated image, so it does
+// not depend on the size the photo was displayed at.
+type Recorte struct {
+	Rotacao int // clockwise, in degrees: 0, 90, 180 or 270
+	X, Y    float64
+	Largura float64
+	Altura  float64
+}
+
+// recorteDoForm reads the rotation and crop fields sent by the crop tool.
+// Missing or invalid values leave the photo unchanged.
+func recorteDoForm(r *http.Request) Recorte {
+	valor := func(campo string) float64 {
+		v, err := strconv.ParseFloat(r.FormValue(campo), 64)
+		if err != nil || math.IsNaN(v) {
+			return 0
+		}
+		return math.Max(0, math.Min(1, v))
+	}
+	rotacao, _ := strconv.Atoi(r.FormValue("rotacao"))
+	rotacao = ((rotacao/90)%4 + 4) % 4 * 90
+	return Recorte{
+		Rotacao: rotacao,
+		X:       valor("recorte_x"),
+		Y:       valor("recorte_y"),
+		Largura: valor("recorte_w"),
+		Altura:  valor("recorte_h"),
+	}
+}
+
+// aplicarRecorte rotates and crops an upright image.
+func aplicarRecorte(img image.Image, rec Recorte) image.Image {
+	// Rotations map onto the equivalent EXIF orientations
+	switch rec.Rotacao {
+	case 90:
+		img = orientarImagem(img, 6)
+	case 180:
+		img = orientarImagem(img, 3)
+	case 270:
+		img = orientarImagem(img, 8)
+	}
+	if rec.Largura <= 0 || rec.Altura <= 0 || (rec.Largura >= 1 && rec.Altura >= 1) {
+		return img
+	}
+
+	b := img.Bounds()
+	x0 := b.Min.X + int(math.Round(rec.X*float64(b.Dx())))
+	y0 := b.Min.Y + int(math.Round(rec.Y*float64(b.Dy())))
+	x1 := x0 + int(math.Round(rec.Largura*float64(b.Dx())))
+	y1 := y0 + int(math.Round(rec.Altura*float64(b.Dy())))
+	area := image.Rect(x0, y0, x1, y1).Intersect(b)
+	if area.Dx() < 1 || area.Dy() < 1 {
+		return img
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, area.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// ordemBytes is binary.LittleEndian or binary.BigEndian.
+type ordemBytes interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffEXIF builds the TIFF block of an EXIF segment whose first IFD holds a
+// Make entry followed by the Orientation entry.
+func tiffEXIF(ordem ordemBytes, orientacao uint16) []byte {
+	var b []byte
+	if ordem == binary.LittleEndian {
+		b = append(b, "II"...)
+	} else {
+		b = append(b, "MM"...)
+	}
+	b = ordem.AppendUint16(b, 42)
+	b = ordem.AppendUint32(b, 8) // first IFD right after the header
+	b = ordem.AppendUint16(b, 2)
+	// Make, ASCII, 4 bytes inline
+	b = ordem.AppendUint16(b, 0x010F)
+	b = ordem.AppendUint16(b, 2)
+	b = ordem.AppendUint32(b, 4)
+	b = append(b, "Foo\x00"...)
+	// Orientation, SHORT, 1 value
+	b = ordem.AppendUint16(b, 0x0112)
+	b = ordem.AppendUint16(b, 3)
+	b = ordem.AppendUint32(b, 1)
+	b = ordem.AppendUint16(b, orientacao)
+	b = append(b, 0, 0)
+	return ordem.AppendUint32(b, 0) // no next IFD
+}
+
+// segmentoJPEG is a marker segment with its length.
+func segmentoJPEG(marcador byte, conteudo []byte) []byte {
+	b := []byte{0xFF, marcador}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(conteudo)+2))
+	return append(b, conteudo...)
+}
+
+// jpegEXIF is the start of a JPEG file: a JFIF segment, the given segments,
+// then the start of the image data.
+func jpegEXIF(segmentos ...[]byte) []byte {
+	b := []byte{0xFF, 0xD8}
+	b = append(b, segmentoJPEG(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))...)
+	for _, s := range segmentos {
+		b = append(b, s...)
+	}
+	return append(b, segmentoJPEG(0xDA, []byte{1, 2, 3})...)
+}
+
+func app1(tiff []byte) []byte {
+	return segmentoJPEG(0xE1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+func TestOrientacaoEXIF(t *testing.T) {
+	ordens := []struct {
+		nome  string
+		ordem ordemBytes
+	}{
+		{"Intel", binary.LittleEndian},
+		{"Motorola", binary.BigEndian},
+	}
+	for _, o := range ordens {
+		for orientacao := 1; orientacao <= 8; orientacao++ {
+			if got := orientacaoEXIF(jpegEXIF(app1(tiffEXIF(o.ordem, uint16(orientacao))))); got != orientacao {
+				t.Errorf("%s, orientation %d: got %d", o.nome, orientacao, got)
+			}
+		}
+		for _, invalida := range []uint16{0, 9, 0xFFFF} {
+			if got := orientacaoEXIF(jpegEXIF(app1(tiffEXIF(o.ordem, invalida)))); got != 1 {
+				t.Errorf("%s, orientation %d: got %d, want 1", o.nome, invalida, got)
+			}
+		}
+	}
+}
+
+// Anything the parser cannot make sense of reads as orientation 1.
+func TestOrientacaoEXIFInvalido(t *testing.T) {
+	valido := tiffEXIF(binary.BigEndian, 6)
+	alterado := func(f func(tiff []byte) []byte) []byte {
+		return f(append([]byte(nil), valido...))
+	}
+	testes := []struct {
+		nome string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a JPEG", []byte("GIF89a......")},
+		{"only the SOI marker", []byte{0xFF, 0xD8}},
+		{"no APP1 segment", jpegEXIF()},
+		{"APP1 with XMP", jpegEXIF(segmentoJPEG(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>")))},
+		{"APP1 after the image data", append(jpegEXIF(), app1(valido)...)},
+		{"end of image first", append([]byte{0xFF, 0xD8, 0xFF, 0xD9}, app1(valido)...)},
+		{"garbage between segments", append([]byte{0xFF, 0xD8, 0x00}, app1(valido)...)},
+		{"segment length below 2", append([]byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x01}, app1(valido)...)},
+		{"segment longer than the file", append([]byte{0xFF, 0xD8}, app1(valido)...)[:24]},
+		{"EXIF header only", jpegEXIF(segmentoJPEG(0xE1, []byte("Exif\x00\x00")))},
+		{"TIFF header cut short", jpegEXIF(app1(valido[:7]))},
+		{"unknown byte order", jpegEXIF(app1(alterado(func(b []byte) []byte { copy(b, "XX"); return b })))},
+		{"IFD offset inside the header", jpegEXIF(app1(alterado(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[4:], 4)
+			return b
+		})))},
+		{"IFD offset past the end", jpegEXIF(app1(alterado(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[4:], uint32(len(b)))
+			return b
+		})))},
+		{"IFD offset at the last byte", jpegEXIF(app1(alterado(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[4:], uint32(len(b)-1))
+			return b
+		})))},
+		{"largest IFD offset", jpegEXIF(app1(alterado(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[4:], 0xFFFFFFFF)
+			return b
+		})))},
+		{"entry count past the end", jpegEXIF(app1(alterado(func(b []byte) []byte {
+			binary.BigEndian.PutUint16(b[8:], 0xFFFF)
+			return b[:22] // the Orientation entry is cut off
+		})))},
+		{"no entries", jpegEXIF(app1(alterado(func(b []byte) []byte {
+			binary.BigEndian.PutUint16(b[8:], 0)
+			return b
+		})))},
+	}
+	for _, tt := range testes {
+		if got := orientacaoEXIF(tt.data); got != 1 {
+			t.Errorf("%s: got %d, want 1", tt.nome, got)
+		}
+	}
+}
+
+// Whatever is cut off or overwritten, the parser returns an orientation and
+// never reads outside the data.
+func TestOrientacaoEXIFNaoEntraEmPanico(t *testing.T) {
+	for _, ordem := range []ordemBytes{binary.LittleEndian, binary.BigEndian} {
+		data := jpegEXIF(app1(tiffEXIF(ordem, 6)))
+		for i := range data {
+			if got := orientacaoEXIF(data[:i]); got != 1 && got != 6 {
+				t.Errorf("cut at %d: got %d", i, got)
+			}
+			for _, b := range []byte{0x00, 0x7F, 0xFF} {
+				alterado := bytes.Clone(data)
+				alterado[i] = b
+				if got := orientacaoEXIF(alterado); got < 1 || got > 8 {
+					t.Errorf("byte %d set to %#x: got %d", i, b, got)
+				}
+			}
+		}
+	}
+}
+
+func TestOrientarImagem(t *testing.T) {
+	// A 3×2 image inside a larger one, so its bounds do not start at 0,0:
+	//
+	//	a b c
+	//	d e f
+	grande := image.NewNRGBA(image.Rect(0, 0, 5, 4))
+	for y, linha := range []string{"abc", "def"} {
+		for x, letra := range linha {
+			grande.Set(x+1, y+1, color.NRGBA{R: uint8(letra), A: 255})
+		}
+	}
+	origem := grande.SubImage(image.Rect(1, 1, 4, 3))
+
+	testes := []struct {
+		orientacao int
+		linhas     []string
+	}{
+		{1, []string{"abc", "def"}},
+		{2, []string{"cba", "fed"}},     // mirrored
+		{3, []string{"fed", "cba"}},     // rotated 180°
+		{4, []string{"def", "abc"}},     // flipped
+		{5, []string{"ad", "be", "cf"}}, // transposed
+		{6, []string{"da", "eb", "fc"}}, // rotated 90° clockwise
+		{7, []string{"fc", "eb", "da"}}, // transversed
+		{8, []string{"cf", "be", "ad"}}, // rotated 90° counterclockwise
+		{0, []string{"abc", "def"}},     // not an orientation
+		{9, []string{"abc", "def"}},     // neither
+	}
+	for _, tt := range testes {
+		img := orientarImagem(origem, tt.orientacao)
+		b := img.Bounds()
+		var linhas []string
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			linha := ""
+			for x := b.Min.X; x < b.Max.X; x++ {
+				linha += string(rune(color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).R))
+			}
+			linhas = append(linhas, linha)
+		}
+		if !reflect.DeepEqual(linhas, tt.linhas) {
+			t.Errorf("orientation %d: got %q, want %q", tt.orientacao, linhas, tt.linhas)
+		}
+	}
+}
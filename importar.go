@@ -0,0 +1,560 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// CampoImportacao is an item field a CSV column can be mapped to.
+type CampoImportacao struct {
+	Nome   string
+	Rotulo string
+}
+
+// camposImportacao lists the fixed fields followed by one "campo:<key>"
+// entry per custom field, the same names escreverItensCSV writes.
+func camposImportacao() []CampoImportacao {
+	campos := []CampoImportacao{
+		{"id", "ID"},
+		{"nome", "Name"},
+		{"descricao", "Description"},
+		{"prateleira", "Rack"},
+		{"estante", "Shelf"},
+		{"compartimento", "Compartment"},
+		{"categoria", "Category"},
+		{"tags", "Tags"},
+		{"quantidade", "Quantity"},
+	}
+	for _, c := range dados.Campos {
+		campos = append(campos, CampoImportacao{"campo:" + c.Nome, c.Rotulo})
+	}
+	return campos
+}
+
+// Other headers recognised for the fixed fields, compared without case or
+// accents
+var aliasesImportacao = map[string]string{
+	"name":        "nome",
+	"description": "descricao",
+	"rack":        "prateleira",
+	"shelf":       "estante",
+	"compartment": "compartimento",
+	"bin":         "compartimento",
+	"category":    "categoria",
+	"tag":         "tags",
+	"quantity":    "quantidade",
+	"qty":         "quantidade",
+	"stock":       "quantidade",
+}
+
+// ColunaImportacao is a column of the CSV file and the field it fills, empty
+// to skip it.
+type ColunaImportacao struct {
+	Cabecalho string
+	Exemplo   string
+	Campo     string
+}
+
+// LinhaImportacao is what importing one row of the file would do: Acao is
+// "create" or "update", or empty when the row has errors.
+type LinhaImportacao struct {
+	Linha  int // line in the file
+	Acao   string
+	Item   Item
+	Erros  []string
+	Avisos []string
+	indice int // position in dados.Itens of the item updated
+}
+
+type PlanoImportacao struct {
+	Linhas        []LinhaImportacao
+	NovosRacks    []string
+	NovasEstantes []string
+	Criados       int
+	Atualizados   int
+	ComErros      int
+}
+
+// registroCSV is a row of the file with the line it starts on.
+type registroCSV struct {
+	Linha   int
+	Valores []string
+}
+
+// lerCSVImportacao reads the header and the rows of the file. Spreadsheets
+// that write ";" between values are detected from the header.
+func lerCSVImportacao(conteudo string) ([]string, []registroCSV, error) {
+	if !utf8.ValidString(conteudo) {
+		return nil, nil, fmt.Errorf("The file is not UTF-8 text, save it from the spreadsheet as \"CSV UTF-8\"")
+	}
+	conteudo = strings.TrimPrefix(conteudo, "\ufeff")
+	cr := csv.NewReader(strings.NewReader(conteudo))
+	cr.FieldsPerRecord = -1
+	if primeira, _, _ := strings.Cut(conteudo, "\n"); strings.Count(primeira, ";") > strings.Count(primeira, ",") {
+		cr.Comma = ';'
+	}
+
+	cabecalho, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read the CSV header: %v", err)
+	}
+	var registros []registroCSV
+	for {
+		valores, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not read the CSV file: %v", err)
+		}
+		linha, _ := cr.FieldPos(0)
+		if strings.TrimSpace(strings.Join(valores, "")) != "" {
+			registros = append(registros, registroCSV{linha, valores})
+		}
+	}
+	if len(registros) == 0 {
+		return nil, nil, fmt.Errorf("The file has no rows below the header")
+	}
+	return cabecalho, registros, nil
+}
+
+// mapearColunas guesses the field of each column from its header.
+func mapearColunas(cabecalho []string) []string {
+	campos := camposImportacao()
+	mapa := make([]string, len(cabecalho))
+	usados := map[string]bool{}
+	for i, titulo := range cabecalho {
+		titulo = normalizarTexto(strings.TrimSpace(titulo))
+		campo := aliasesImportacao[titulo]
+		for _, c := range campos {
+			if campo == "" && (titulo == c.Nome || titulo == strings.TrimPrefix(c.Nome, "campo:") || titulo == normalizarTexto(c.Rotulo)) {
+				campo = c.Nome
+			}
+		}
+		if campo != "" && !usados[campo] {
+			mapa[i] = campo
+			usados[campo] = true
+		}
+	}
+	return mapa
+}
+
+// validarMapa checks that no field is filled by two columns and that the
+// columns needed to find items by name and location are there.
+func validarMapa(mapa []string, chave string) error {
+	mapeados := map[string]bool{}
+	rotulos := map[string]string{}
+	for _, c := range camposImportacao() {
+		rotulos[c.Nome] = c.Rotulo
+	}
+	for _, campo := range mapa {
+		if campo == "" {
+			continue
+		}
+		if _, ok := rotulos[campo]; !ok {
+			return fmt.Errorf("Unknown field %s", campo)
+		}
+		if mapeados[campo] {
+			return fmt.Errorf("%s is mapped to more than one column", rotulos[campo])
+		}
+		mapeados[campo] = true
+	}
+	if chave == "local" && !(mapeados["nome"] && mapeados["prateleira"] && mapeados["estante"]) {
+		return fmt.Errorf("Map columns to Name, Rack and Shelf to match items by name and location")
+	}
+	return nil
+}
+
+type parLocal struct {
+	Prateleira string
+	Estante    string
+}
+
+// chaveNomeLocal identifies an item by name and location, compartment
+// included only when given.
+func chaveNomeLocal(nome, prateleira, estante, compartimento string) string {
+	return strings.Join([]string{normalizarTexto(strings.TrimSpace(nome)), prateleira, estante, compartimento}, "\x00")
+}
+
+// planejarImportacao works out what importing the rows would do without
+// touching the inventory. Items are matched by ID (chave "id") or by name and
+// location (chave "local"); rows that match nothing create items.
+func planejarImportacao(registros []registroCSV, mapa []string, chave string, criarLocais bool) PlanoImportacao {
+	var plano PlanoImportacao
+
+	porID := make(map[int]int, len(dados.Itens))
+	porNomeLocal := map[string][]int{}
+	for i, item := range dados.Itens {
+		porID[item.ID] = i
+		if chave == "local" {
+			k := chaveNomeLocal(item.Nome, item.Prateleira, item.Estante, "")
+			porNomeLocal[k] = append(porNomeLocal[k], i)
+			if item.Compartimento != "" {
+				k = chaveNomeLocal(item.Nome, item.Prateleira, item.Estante, item.Compartimento)
+				porNomeLocal[k] = append(porNomeLocal[k], i)
+			}
+		}
+	}
+	alvos := map[int]int{}      // item updated -> line updating it
+	criando := map[string]int{} // name and location created -> line creating it
+
+	// First work out each row on its own
+	for _, registro := range registros {
+		valores := map[string]string{}
+		for i, campo := range mapa {
+			if campo != "" && i < len(registro.Valores) {
+				valores[campo] = restaurarFormula(strings.TrimSpace(registro.Valores[i]))
+			}
+		}
+		linha := LinhaImportacao{Linha: registro.Linha, indice: -1}
+		erro := func(formato string, args ...any) {
+			linha.Erros = append(linha.Erros, fmt.Sprintf(formato, args...))
+		}
+
+		switch chave {
+		case "id":
+			if valor := valores["id"]; valor != "" {
+				id, err := strconv.Atoi(valor)
+				if i, ok := porID[id]; err == nil && ok {
+					linha.indice = i
+				} else {
+					erro("Item %s not found", valor)
+				}
+			}
+		case "local":
+			k := chaveNomeLocal(valores["nome"], valores["prateleira"], valores["estante"], valores["compartimento"])
+			switch encontrados := porNomeLocal[k]; {
+			case len(encontrados) == 1:
+				linha.indice = encontrados[0]
+			case len(encontrados) > 1:
+				erro("%d items are called %s in this location, give the compartment to tell them apart", len(encontrados), valores["nome"])
+			case criando[k] != 0:
+				erro("Same name and location as line %d", criando[k])
+			default:
+				criando[k] = registro.Linha
+			}
+		}
+		if linha.indice >= 0 {
+			id := dados.Itens[linha.indice].ID
+			if outra, ok := alvos[id]; ok {
+				erro("Item %d is already updated by line %d", id, outra)
+				linha.indice = -1
+			} else {
+				alvos[id] = registro.Linha
+			}
+		}
+
+		item := Item{}
+		if linha.indice >= 0 {
+			item = dados.Itens[linha.indice]
+		}
+		for campo, valor := range valores {
+			switch campo {
+			case "nome":
+				item.Nome = valor
+			case "descricao":
+				item.Descricao = valor
+			case "prateleira":
+				item.Prateleira = valor
+			case "estante":
+				item.Estante = valor
+			case "compartimento":
+				item.Compartimento = valor
+			case "categoria":
+				item.Categoria = valor
+			case "tags":
+				item.Tags = normalizarTags(valor)
+			case "quantidade":
+				// A blank cell keeps the stock of an updated item
+				if valor == "" {
+					break
+				}
+				n, err := strconv.Atoi(valor)
+				if err != nil || n < 0 {
+					erro("Quantity must be a whole number of zero or more, not %q", valor)
+					break
+				}
+				item.Quantidade = n
+			}
+		}
+		// An empty or missing compartment keeps the one of an item that
+		// stays on its rack and shelf, and is assigned below otherwise
+		if linha.indice >= 0 && valores["compartimento"] == "" {
+			antes := dados.Itens[linha.indice]
+			if item.Prateleira == antes.Prateleira && item.Estante == antes.Estante {
+				item.Compartimento = antes.Compartimento
+			} else {
+				item.Compartimento = ""
+			}
+		}
+		if item.Nome == "" {
+			erro("Name is required")
+		}
+		if err := verificarCategoria(item.Categoria); err != nil {
+			erro("%v", err)
+		}
+
+		// Custom fields are checked against the final category, keeping
+		// the stored value of those without a column
+		campos := map[string]string{}
+		for _, c := range dados.Campos {
+			if !campoAplicavel(c, item.Categoria) {
+				continue
+			}
+			valor, mapeado := valores["campo:"+c.Nome]
+			if !mapeado {
+				valor = item.Campos[c.Nome]
+			}
+			valor, err := validarValorCampo(c, valor)
+			if err != nil {
+				erro("%v", err)
+			}
+			if valor != "" {
+				campos[c.Nome] = valor
+			}
+		}
+		item.Campos = nil
+		if len(campos) > 0 {
+			item.Campos = campos
+		}
+
+		linha.Item = item
+		plano.Linhas = append(plano.Linhas, linha)
+	}
+
+	// Then place the items, counting what every row before puts in each
+	// location. Updated items are counted where they end up, so they are
+	// left out of the current occupation.
+	racks, estantes := map[string]bool{}, map[string]bool{}
+	for _, r := range dados.Racks {
+		racks[r.Nome] = true
+	}
+	for _, e := range dados.Estantes {
+		estantes[e.Nome] = true
+	}
+	ocupados := map[parLocal]map[string]int{}
+	ocupar := func(item Item) {
+		par := parLocal{item.Prateleira, item.Estante}
+		if ocupados[par] == nil {
+			ocupados[par] = map[string]int{}
+		}
+		ocupados[par][item.Compartimento]++
+	}
+	for _, item := range dados.Itens {
+		if _, ok := alvos[item.ID]; !ok {
+			ocupar(item)
+		}
+	}
+
+	// Updates that stay where they are keep their place before any other
+	// row takes it
+	movido := func(l LinhaImportacao) bool {
+		if l.indice < 0 {
+			return true
+		}
+		antes := dados.Itens[l.indice]
+		return l.Item.Prateleira != antes.Prateleira || l.Item.Estante != antes.Estante || l.Item.Compartimento != antes.Compartimento
+	}
+	for _, l := range plano.Linhas {
+		if len(l.Erros) == 0 && !movido(l) {
+			ocupar(l.Item)
+		}
+	}
+
+	for i := range plano.Linhas {
+		l := &plano.Linhas[i]
+		if movido(*l) {
+			item := &l.Item
+			if item.Prateleira == "" || item.Estante == "" {
+				l.Erros = append(l.Erros, "Rack and shelf are required")
+			}
+			if item.Prateleira != "" && !racks[item.Prateleira] {
+				if criarLocais {
+					racks[item.Prateleira] = true
+					plano.NovosRacks = append(plano.NovosRacks, item.Prateleira)
+					l.Avisos = append(l.Avisos, "Rack "+item.Prateleira+" will be created")
+				} else {
+					l.Erros = append(l.Erros, "Rack "+item.Prateleira+" does not exist")
+				}
+			}
+			if item.Estante != "" && !estantes[item.Estante] {
+				if criarLocais {
+					estantes[item.Estante] = true
+					plano.NovasEstantes = append(plano.NovasEstantes, item.Estante)
+					l.Avisos = append(l.Avisos, "Shelf "+item.Estante+" will be created")
+				} else {
+					l.Erros = append(l.Erros, "Shelf "+item.Estante+" does not exist")
+				}
+			}
+			if len(l.Erros) == 0 {
+				par := parLocal{item.Prateleira, item.Estante}
+				if item.Compartimento == "" {
+					if nome, ok := proximoCompartimentoLivre(item.Prateleira, item.Estante, ocupados[par]); ok {
+						item.Compartimento = nome
+						l.Avisos = append(l.Avisos, "Compartment "+nome+" assigned")
+					} else {
+						l.Erros = append(l.Erros, fmt.Sprintf("No free compartment left in Rack %s, Shelf %s", item.Prateleira, item.Estante))
+					}
+				} else if err := verificarCapacidade(item.Prateleira, item.Estante, item.Compartimento, ocupados[par][item.Compartimento]); err != nil {
+					l.Erros = append(l.Erros, err.Error())
+				}
+			}
+			if len(l.Erros) == 0 {
+				ocupar(*item)
+			}
+		}
+
+		switch {
+		case len(l.Erros) > 0:
+			plano.ComErros++
+		case l.indice >= 0:
+			l.Acao = "update"
+			plano.Atualizados++
+		default:
+			l.Acao = "create"
+			plano.Criados++
+		}
+	}
+	return plano
+}
+
+// aplicarImportacao carries out a plan without errors and saves once,
+// returning the IDs of the items created and updated.
+func aplicarImportacao(plano PlanoImportacao) []int {
+	for _, nome := range plano.NovosRacks {
+		dados.Racks = append(dados.Racks, Rack{Nome: nome})
+	}
+	for _, nome := range plano.NovasEstantes {
+		dados.Estantes = append(dados.Estantes, Estante{Nome: nome})
+	}
+
+	var ids []int
+	agora := time.Now()
+	for _, l := range plano.Linhas {
+		item := l.Item
+		item.Modificado = agora
+		if l.Acao == "create" {
+			item.ID = proximoIDItem()
+			dados.Itens = append(dados.Itens, item)
+		} else {
+			dados.Itens[l.indice] = item
+		}
+		indexarItem(item)
+		ids = append(ids, item.ID)
+	}
+	salvarDados()
+	return ids
+}
+
+// importarItens reads a CSV file and shows what importing it would do. The
+// file travels with the form, so the column mapping can be changed and the
+// preview run again before importing.
+func importarItens(w http.ResponseWriter, r *http.Request) {
+	pagina := PaginaImportacao{Chave: "id"}
+	if r.Method != http.MethodPost {
+		renderImportacao(w, pagina)
+		return
+	}
+	if err := lerFormularioUpload(w, r); err != nil {
+		pagina.Error = err.Error()
+		renderImportacao(w, pagina)
+		return
+	}
+	if r.FormValue("chave") == "local" {
+		pagina.Chave = "local"
+	}
+	pagina.CriarLocais = r.FormValue("criar_locais") == "sim"
+
+	conteudo := r.FormValue("conteudo")
+	mapa := r.Form["coluna"]
+	arquivo, _, err := r.FormFile("arquivo")
+	if err == nil {
+		defer arquivo.Close()
+		bytes, err := io.ReadAll(arquivo)
+		if err != nil {
+			pagina.Error = "Could not read the file: " + err.Error()
+			renderImportacao(w, pagina)
+			return
+		}
+		conteudo = string(bytes)
+		mapa = nil
+	} else if !errors.Is(err, http.ErrMissingFile) && !errors.Is(err, http.ErrNotMultipart) {
+		pagina.Error = "Could not read the file: " + err.Error()
+		renderImportacao(w, pagina)
+		return
+	}
+	if conteudo == "" {
+		pagina.Error = "Choose a CSV file to import"
+		renderImportacao(w, pagina)
+		return
+	}
+
+	cabecalho, registros, err := lerCSVImportacao(conteudo)
+	if err != nil {
+		pagina.Error = err.Error()
+		renderImportacao(w, pagina)
+		return
+	}
+	if len(mapa) != len(cabecalho) {
+		mapa = mapearColunas(cabecalho)
+	}
+	pagina.Conteudo = conteudo
+	for i, titulo := range cabecalho {
+		coluna := ColunaImportacao{Cabecalho: titulo, Campo: mapa[i]}
+		for _, registro := range registros {
+			if i < len(registro.Valores) && strings.TrimSpace(registro.Valores[i]) != "" {
+				coluna.Exemplo = registro.Valores[i]
+				break
+			}
+		}
+		pagina.Colunas = append(pagina.Colunas, coluna)
+	}
+	if err := validarMapa(mapa, pagina.Chave); err != nil {
+		pagina.Error = err.Error()
+		renderImportacao(w, pagina)
+		return
+	}
+
+	plano := planejarImportacao(registros, mapa, pagina.Chave, pagina.CriarLocais)
+	pagina.Plano = &plano
+	if r.FormValue("acao") == "importar" {
+		if plano.ComErros > 0 {
+			pagina.Error = fmt.Sprintf("Nothing was imported: %d row(s) have errors", plano.ComErros)
+			renderImportacao(w, pagina)
+			return
+		}
+		ids := aplicarImportacao(plano)
+		registrarAuditoria(r, "import", ids, fmt.Sprintf("%d created, %d updated", plano.Criados, plano.Atualizados))
+		pagina.Sucesso = fmt.Sprintf("Imported %d item(s): %d created, %d updated", len(ids), plano.Criados, plano.Atualizados)
+		pagina.Conteudo = ""
+		pagina.Colunas = nil
+	}
+	renderImportacao(w, pagina)
+}
+
+type PaginaImportacao struct {
+	Conteudo    string
+	Colunas     []ColunaImportacao
+	Campos      []CampoImportacao
+	Chave       string
+	CriarLocais bool
+	Plano       *PlanoImportacao
+	Error       string
+	Sucesso     string
+	Config      Config
+}
+
+func renderImportacao(w http.ResponseWriter, pagina PaginaImportacao) {
+	pagina.Campos = camposImportacao()
+	pagina.Config = config
+	tmpl := template.Must(template.ParseFiles("templates/importar.html"))
+	tmpl.Execute(w, pagina)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanejarImportacao(t *testing.T) {
+	usarDados(t, Inventario{
+		Racks:    []Rack{{Nome: "P-0001"}, {Nome: "P-0002"}},
+		Estantes: []Estante{{Nome: "L1"}, {Nome: "L2"}},
+		Compartimentos: []Compartimento{
+			{Prateleira: "P-0002", Estante: "L2", Nome: "A", Capacidade: 1},
+			{Prateleira: "P-0002", Estante: "L2", Nome: "B", Capacidade: 2},
+		},
+		Categorias: []Categoria{{Nome: "Fixacao"}},
+		Itens: []Item{
+			{ID: 1, Nome: "Parafuso M4", Prateleira: "P-0001", Estante: "L1", Compartimento: "1", Quantidade: 10},
+			{ID: 2, Nome: "Porca M4", Prateleira: "P-0001", Estante: "L1", Compartimento: "2", Quantidade: 5},
+			{ID: 3, Nome: "Arruela", Prateleira: "P-0002", Estante: "L2", Compartimento: "A", Quantidade: 3},
+		},
+	})
+
+	// linhaEsperada is what a row should plan: an error containing erro, or
+	// the action with the compartment and quantity the item ends up with
+	type linhaEsperada struct {
+		acao          string
+		compartimento string
+		quantidade    int
+		erro          string
+	}
+	testes := []struct {
+		nome        string
+		csv         string
+		chave       string
+		criarLocais bool
+		linhas      []linhaEsperada
+		novosRacks  []string
+	}{
+		{
+			"update by id keeps a blank quantity", "id,nome,quantidade\n1,Parafuso M4 inox,\n2,Porca M4,7", "id", false,
+			[]linhaEsperada{{"update", "1", 10, ""}, {"update", "2", 7, ""}}, nil,
+		},
+		{
+			"invalid quantity", "id,nome,quantidade\n1,Parafuso M4,-3", "id", false,
+			[]linhaEsperada{{erro: "Quantity must be a whole number"}}, nil,
+		},
+		{
+			"unknown id", "id,nome\n99,Mola", "id", false,
+			[]linhaEsperada{{erro: "Item 99 not found"}}, nil,
+		},
+		{
+			"name is required", "id,nome\n1,", "id", false,
+			[]linhaEsperada{{erro: "Name is required"}}, nil,
+		},
+		{
+			"unknown category", "nome,prateleira,estante,categoria\nMola,P-0001,L1,Nada", "id", false,
+			[]linhaEsperada{{erro: "Category Nada does not exist"}}, nil,
+		},
+		{
+			"same item twice", "id,quantidade\n1,4\n1,5", "id", false,
+			[]linhaEsperada{{"update", "1", 4, ""}, {erro: "Item 1 is already updated by line 2"}}, nil,
+		},
+		{
+			"new items get the next free compartments", "nome,prateleira,estante,quantidade\nLixa,P-0001,L1,4\nLima,P-0001,L1,",
+			"id", false,
+			[]linhaEsperada{{"create", "3", 4, ""}, {"create", "4", 0, ""}}, nil,
+		},
+		{
+			"occupied location", "nome,prateleira,estante,compartimento\nLixa,P-0001,L1,2", "id", false,
+			[]linhaEsperada{{erro: "An item already exists in this location"}}, nil,
+		},
+		{
+			"full compartments", "nome,prateleira,estante,compartimento\nMola,P-0002,L2,A\nLixa,P-0002,L2,B\nLima,P-0002,L2,B\nFita,P-0002,L2,B",
+			"id", false,
+			[]linhaEsperada{
+				{erro: "Compartment A (Shelf: L2, Rack: P-0002) is full (1/1)"},
+				{"create", "B", 0, ""},
+				{"create", "B", 0, ""},
+				{erro: "is full (2/2)"},
+			},
+			nil,
+		},
+		{
+			"undefined compartment", "nome,prateleira,estante,compartimento\nMola,P-0002,L2,C", "id", false,
+			[]linhaEsperada{{erro: "Compartment C is not defined"}}, nil,
+		},
+		{
+			"a moved item frees its compartment", "id,nome,prateleira,estante,compartimento\n3,Arruela,P-0002,L2,B\n,Mola,P-0002,L2,A",
+			"id", false,
+			[]linhaEsperada{{"update", "B", 3, ""}, {"create", "A", 0, ""}}, nil,
+		},
+		{
+			"a moved item without a compartment column gets a new one", "id,prateleira,estante\n1,P-0002,L2\n2,P-0001,L2",
+			"id", false,
+			[]linhaEsperada{{"update", "B", 10, ""}, {"update", "1", 5, ""}}, nil,
+		},
+		{
+			"items can swap places", "id,compartimento\n1,2\n2,1", "id", false,
+			[]linhaEsperada{{"update", "2", 10, ""}, {"update", "1", 5, ""}}, nil,
+		},
+		{
+			"missing rack", "nome,prateleira,estante\nMola,P-0009,L1", "id", false,
+			[]linhaEsperada{{erro: "Rack P-0009 does not exist"}}, nil,
+		},
+		{
+			"missing rack is created", "nome,prateleira,estante\nMola,P-0009,L1\nLixa,P-0009,L1", "id", true,
+			[]linhaEsperada{{"create", "1", 0, ""}, {"create", "2", 0, ""}}, []string{"P-0009"},
+		},
+		{
+			"rack and shelf are required", "nome,estante\nMola,L1", "id", false,
+			[]linhaEsperada{{erro: "Rack and shelf are required"}}, nil,
+		},
+		{
+			"match by name and location", "nome,prateleira,estante,quantidade\nparafuso m4,P-0001,L1,11\nMola,P-0001,L1,2",
+			"local", false,
+			[]linhaEsperada{{"update", "1", 11, ""}, {"create", "3", 2, ""}}, nil,
+		},
+		{
+			"the same new item twice", "nome,prateleira,estante\nMola,P-0001,L1\nMola,P-0001,L1", "local", false,
+			[]linhaEsperada{{"create", "3", 0, ""}, {erro: "Same name and location as line 2"}}, nil,
+		},
+	}
+	for _, tt := range testes {
+		t.Run(tt.nome, func(t *testing.T) {
+			cabecalho, registros, err := lerCSVImportacao(tt.csv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plano := planejarImportacao(registros, mapearColunas(cabecalho), tt.chave, tt.criarLocais)
+			if len(plano.Linhas) != len(tt.linhas) {
+				t.Fatalf("planned %d rows, want %d", len(plano.Linhas), len(tt.linhas))
+			}
+			var criados, atualizados, comErros int
+			for i, l := range plano.Linhas {
+				esperada := tt.linhas[i]
+				if esperada.erro != "" {
+					comErros++
+					if l.Acao != "" || !strings.Contains(strings.Join(l.Erros, "\n"), esperada.erro) {
+						t.Errorf("line %d: %q %v, want error %q", l.Linha, l.Acao, l.Erros, esperada.erro)
+					}
+					continue
+				}
+				if esperada.acao == "create" {
+					criados++
+				} else {
+					atualizados++
+				}
+				if l.Acao != esperada.acao || len(l.Erros) > 0 {
+					t.Errorf("line %d: %q %v, want %q", l.Linha, l.Acao, l.Erros, esperada.acao)
+				}
+				if l.Item.Compartimento != esperada.compartimento || l.Item.Quantidade != esperada.quantidade {
+					t.Errorf("line %d: compartment %q, quantity %d, want %q, %d",
+						l.Linha, l.Item.Compartimento, l.Item.Quantidade, esperada.compartimento, esperada.quantidade)
+				}
+			}
+			if plano.Criados != criados || plano.Atualizados != atualizados || plano.ComErros != comErros {
+				t.Errorf("counted %d created, %d updated, %d with errors, want %d, %d, %d",
+					plano.Criados, plano.Atualizados, plano.ComErros, criados, atualizados, comErros)
+			}
+			if !reflect.DeepEqual(plano.NovosRacks, tt.novosRacks) {
+				t.Errorf("new racks %v, want %v", plano.NovosRacks, tt.novosRacks)
+			}
+		})
+	}
+}
+
+// Cells the export neutralized come back as they were typed.
+func TestPlanejarImportacaoFormula(t *testing.T) {
+	usarDados(t, Inventario{
+		Racks:    []Rack{{Nome: "P-0001"}},
+		Estantes: []Estante{{Nome: "L1"}},
+	})
+	cabecalho, registros, err := lerCSVImportacao("nome,prateleira,estante\n'=Cabo,P-0001,L1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plano := planejarImportacao(registros, mapearColunas(cabecalho), "id", false)
+	if got := plano.Linhas[0].Item.Nome; got != "=Cabo" {
+		t.Errorf("name %q, want %q", got, "=Cabo")
+	}
+}
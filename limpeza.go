@@ -0,0 +1,200 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Served directly by the users page, never referenced from the data files.
+const fotoPadraoUsuario = "default-user.png"
+
+// FotoAusente is a reference to a photo missing from the photo store. Exactly
+// one of ItemID and Usuario is set.
+type FotoAusente struct {
+	ItemID  int
+	Nome    string
+	Usuario string
+	Foto    string
+}
+
+// RelatorioFotos lists the files in the photo store that nothing references
+// (e.g. "thumbs/abc.jpg") and the references without a file.
+type RelatorioFotos struct {
+	Orfas       []string
+	TamanhoOrfa int64
+	Ausentes    []FotoAusente
+}
+
+// fotosReferenciadas returns every photo filename used by an item or a user.
+func fotosReferenciadas() map[string]bool {
+	ref := map[string]bool{fotoPadraoUsuario: true}
+	for _, item := range dados.Itens {
+		for _, foto := range item.Fotos {
+			ref[foto] = true
+		}
+	}
+	for _, u := range usuariosData.Usuarios {
+		if u.Foto != "" {
+			ref[u.Foto] = true
+		}
+	}
+	return ref
+}
+
+// fotoEmUso reports whether a photo is still referenced. Since photos are
+// stored by content hash the same file may be shared by several items.
+func fotoEmUso(filename string) bool {
+	return fotosReferenciadas()[filename]
+}
+
+// verificarFotos lists the photo store once and compares it with the
+// references in the data files.
+func verificarFotos() (RelatorioFotos, error) {
+	var rel RelatorioFotos
+	ref := fotosReferenciadas()
+
+	objetos, err := armazenamento.Listar()
+	if err != nil {
+		return rel, err
+	}
+	existe := map[string]bool{fotoPadraoUsuario: true}
+	for _, o := range objetos {
+		if !strings.Contains(o.Nome, "/") {
+			existe[o.Nome] = true
+		}
+		// Renditions belong to the original with the same filename
+		if ref[path.Base(o.Nome)] {
+			continue
+		}
+		rel.Orfas = append(rel.Orfas, o.Nome)
+		rel.TamanhoOrfa += o.Tamanho
+	}
+	sort.Strings(rel.Orfas)
+
+	for _, item := range dados.Itens {
+		for _, foto := range item.Fotos {
+			if !existe[foto] {
+				rel.Ausentes = append(rel.Ausentes, FotoAusente{ItemID: item.ID, Nome: item.Nome, Foto: foto})
+			}
+		}
+	}
+	for _, u := range usuariosData.Usuarios {
+		if u.Foto != "" && !existe[u.Foto] {
+			rel.Ausentes = append(rel.Ausentes, FotoAusente{Usuario: u.Username, Foto: u.Foto})
+		}
+	}
+	return rel, nil
+}
+
+// validarFotos logs the problems found in photo storage at startup without
+// changing anything.
+func validarFotos() {
+	rel, err := verificarFotos()
+	if err != nil {
+		log.Printf("Error checking photo storage: %v", err)
+		return
+	}
+	for _, a := range rel.Ausentes {
+		if a.Usuario != "" {
+			log.Printf("User %s references missing photo %s", a.Usuario, a.Foto)
+		} else {
+			log.Printf("Item %d (%s) references missing photo %s", a.ItemID, a.Nome, a.Foto)
+		}
+	}
+	if len(rel.Orfas) > 0 {
+		log.Printf("%d unreferenced photo files (%s), see /fotos", len(rel.Orfas), tamanhoLegivel(rel.TamanhoOrfa))
+	}
+}
+
+func removerFotosOrfas(rel RelatorioFotos) int {
+	removidas := 0
+	for _, caminho := range rel.Orfas {
+		if err := armazenamento.Remover(caminho); err != nil {
+			log.Printf("Error removing photo %s: %v", caminho, err)
+			continue
+		}
+		removidas++
+	}
+	return removidas
+}
+
+// removerReferenciasAusentes drops the photos listed in the report from the
+// items and users that reference them.
+func removerReferenciasAusentes(rel RelatorioFotos) {
+	ausentes := map[string]bool{}
+	for _, a := range rel.Ausentes {
+		ausentes[a.Foto] = true
+	}
+	for i, item := range dados.Itens {
+		var fotos []string
+		for _, foto := range item.Fotos {
+			if !ausentes[foto] {
+				fotos = append(fotos, foto)
+			}
+		}
+		dados.Itens[i].Fotos = fotos
+		atualizarFotoPrincipal(&dados.Itens[i])
+	}
+	for i, u := range usuariosData.Usuarios {
+		if ausentes[u.Foto] {
+			usuariosData.Usuarios[i].Foto = ""
+		}
+	}
+	salvarDados()
+	salvarUsuarios()
+}
+
+// limparFotos runs the photo garbage collector from the command line. It
+// only reports unless apagar is set.
+func limparFotos(apagar bool) {
+	rel, err := verificarFotos()
+	if err != nil {
+		log.Fatalf("Error checking photo storage: %v", err)
+	}
+	for _, caminho := range rel.Orfas {
+		log.Printf("Unreferenced: %s", caminho)
+	}
+	for _, a := range rel.Ausentes {
+		log.Printf("Missing: %s (item %d %s%s)", a.Foto, a.ItemID, a.Nome, a.Usuario)
+	}
+	if apagar {
+		log.Printf("Removed %d unreferenced files (%s)", removerFotosOrfas(rel), tamanhoLegivel(rel.TamanhoOrfa))
+	} else {
+		log.Printf("%d unreferenced files (%s), %d missing photos; run with --delete to remove the unreferenced files",
+			len(rel.Orfas), tamanhoLegivel(rel.TamanhoOrfa), len(rel.Ausentes))
+	}
+}
+
+func verificarArmazenamento(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("fotos.html").Funcs(template.FuncMap{
+		"tamanho": tamanhoLegivel,
+	}).ParseFiles("templates/fotos.html"))
+	rel, err := verificarFotos()
+	if err != nil {
+		http.Error(w, "Error checking photo storage: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	tmpl.Execute(w, rel)
+}
+
+func limparArmazenamento(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		rel, err := verificarFotos()
+		if err != nil {
+			http.Error(w, "Error checking photo storage: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		switch r.FormValue("acao") {
+		case "orfas":
+			removerFotosOrfas(rel)
+		case "ausentes":
+			removerReferenciasAusentes(rel)
+		}
+	}
+	http.Redirect(w, r, "/fotos", http.StatusSeeOther)
+}
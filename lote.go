@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MovimentoItem struct {
+	Item          Item
+	Compartimento string
+}
+
+func buscarItem(id int) (int, bool) {
+	for i, item := range dados.Itens {
+		if item.ID == id {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// iniciarProximoID moves the ID counter above every loaded item, for data
+// saved before the counter existed or edited by hand.
+func iniciarProximoID() {
+	for _, item := range dados.Itens {
+		if item.ID >= dados.ProximoID {
+			dados.ProximoID = item.ID + 1
+		}
+	}
+}
+
+// proximoIDItem reserves the next item ID. The counter is saved with the
+// data, so IDs are never reused after items are deleted, not even the ID of
+// the last one.
+func proximoIDItem() int {
+	if dados.ProximoID < 1 {
+		dados.ProximoID = 1
+	}
+	id := dados.ProximoID
+	dados.ProximoID++
+	return id
+}
+
+// atualizarItensEmLote applies fn to every item in ids and saves once. All
+// IDs are checked before anything changes, so either every item is updated
+// or none is.
+func atualizarItensEmLote(ids []int, fn func(*Item)) error {
+	var indices []int
+	for _, id := range ids {
+		i, ok := buscarItem(id)
+		if !ok {
+			return fmt.Errorf("Item %d not found", id)
+		}
+		indices = append(indices, i)
+	}
+	for _, i := range indices {
+		fn(&dados.Itens[i])
+		dados.Itens[i].Modificado = time.Now()
+		indexarItem(dados.Itens[i])
+	}
+	salvarDados()
+	return nil
+}
+
+func deletarItensEmLote(ids []int) error {
+	remover := map[int]bool{}
+	for _, id := range ids {
+		if _, ok := buscarItem(id); !ok {
+			return fmt.Errorf("Item %d not found", id)
+		}
+		remover[id] = true
+	}
+	var itens, removidos []Item
+	for _, item := range dados.Itens {
+		if remover[item.ID] {
+			removidos = append(removidos, item)
+		} else {
+			itens = append(itens, item)
+		}
+	}
+	dados.Itens = itens
+	salvarDados()
+	for _, item := range removidos {
+		removerDoIndice(item.ID)
+		removerArquivosItem(item)
+	}
+	return nil
+}
+
+// idsSelecionados reads the item IDs sent as repeated "id" values, each one
+// once.
+func idsSelecionados(r *http.Request) []int {
+	var ids []int
+	vistos := map[int]bool{}
+	for _, v := range r.Form["id"] {
+		if id, err := strconv.Atoi(v); err == nil && !vistos[id] {
+			vistos[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// proximoCompartimentoLivre picks the first compartment of a rack/shelf pair
+// with room left. Pairs without defined compartments get the lowest numeric
+// label not yet in use.
+func proximoCompartimentoLivre(prateleira, estante string, ocupados map[string]int) (string, bool) {
+	if possuiCompartimentos(prateleira, estante) {
+		for _, c := range dados.Compartimentos {
+			if c.Prateleira == prateleira && c.Estante == estante && (c.Capacidade <= 0 || ocupados[c.Nome] < c.Capacidade) {
+				return c.Nome, true
+			}
+		}
+		return "", false
+	}
+	for n := 1; ; n++ {
+		nome := strconv.Itoa(n)
+		if ocupados[nome] == 0 {
+			return nome, true
+		}
+	}
+}
+
+// planejarMovimento assigns a target compartment to every item without
+// touching the inventory. Any conflict means nothing should be moved.
+func planejarMovimento(ids []int, prateleira, estante string) ([]MovimentoItem, []string) {
+	movendo := map[int]bool{}
+	for _, id := range ids {
+		movendo[id] = true
+	}
+
+	ocupados := map[string]int{}
+	for _, item := range dados.Itens {
+		if !movendo[item.ID] && item.Prateleira == prateleira && item.Estante == estante {
+			ocupados[item.Compartimento]++
+		}
+	}
+
+	var plano []MovimentoItem
+	var conflitos []string
+	for _, id := range ids {
+		i, ok := buscarItem(id)
+		if !ok {
+			conflitos = append(conflitos, fmt.Sprintf("Item %d not found", id))
+			continue
+		}
+		item := dados.Itens[i]
+		nome, ok := proximoCompartimentoLivre(prateleira, estante, ocupados)
+		if !ok {
+			conflitos = append(conflitos, fmt.Sprintf("No free compartment left in Rack %s, Shelf %s for %s", prateleira, estante, item.Nome))
+			continue
+		}
+		ocupados[nome]++
+		plano = append(plano, MovimentoItem{Item: item, Compartimento: nome})
+	}
+	return plano, conflitos
+}
+
+func moverItensLote(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	ids := idsSelecionados(r)
+	origemPrateleira := r.FormValue("origem_prateleira")
+	origemEstante := r.FormValue("origem_estante")
+	origemCompartimento := r.FormValue("origem_compartimento")
+	destinoPrateleira := r.FormValue("destino_prateleira")
+	destinoEstante := r.FormValue("destino_estante")
+
+	// Without an explicit selection, pick every item in the source location
+	porLocalizacao := len(ids) == 0
+	if porLocalizacao && origemPrateleira != "" {
+		for _, item := range dados.Itens {
+			if item.Prateleira == origemPrateleira &&
+				(origemEstante == "" || item.Estante == origemEstante) &&
+				(origemCompartimento == "" || item.Compartimento == origemCompartimento) {
+				ids = append(ids, item.ID)
+			}
+		}
+	}
+
+	var selecionados []Item
+	for _, id := range ids {
+		if i, ok := buscarItem(id); ok {
+			selecionados = append(selecionados, dados.Itens[i])
+		}
+	}
+
+	var erro string
+	var conflitos []string
+	var movidos []MovimentoItem
+
+	if r.Method == http.MethodPost {
+		switch {
+		case len(ids) == 0:
+			erro = "No items selected"
+		case destinoPrateleira == "" || destinoEstante == "":
+			erro = "Target rack and shelf are required"
+		default:
+			plano, c := planejarMovimento(ids, destinoPrateleira, destinoEstante)
+			conflitos = c
+			if len(conflitos) == 0 {
+				destinos := map[int]string{}
+				for _, m := range plano {
+					destinos[m.Item.ID] = m.Compartimento
+				}
+				atualizarItensEmLote(ids, func(item *Item) {
+					item.Prateleira = destinoPrateleira
+					item.Estante = destinoEstante
+					item.Compartimento = destinos[item.ID]
+				})
+				registrarAuditoria(r, "move", ids, fmt.Sprintf("Rack %s, Shelf %s", destinoPrateleira, destinoEstante))
+				movidos = plano
+				selecionados = nil
+			}
+		}
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/mover_lote.html"))
+	tmpl.Execute(w, struct {
+		Itens               []Item
+		PorLocalizacao      bool
+		OrigemPrateleira    string
+		OrigemEstante       string
+		OrigemCompartimento string
+		DestinoPrateleira   string
+		DestinoEstante      string
+		Estantes            []Estante
+		Racks               []Rack
+		Error               string
+		Conflitos           []string
+		Movidos             []MovimentoItem
+		Config              Config
+	}{
+		Itens:               selecionados,
+		PorLocalizacao:      porLocalizacao,
+		OrigemPrateleira:    origemPrateleira,
+		OrigemEstante:       origemEstante,
+		OrigemCompartimento: origemCompartimento,
+		DestinoPrateleira:   destinoPrateleira,
+		DestinoEstante:      destinoEstante,
+		Estantes:            dados.Estantes,
+		Racks:               dados.Racks,
+		Error:               erro,
+		Conflitos:           conflitos,
+		Movidos:             movidos,
+		Config:              config,
+	})
+}
+
+func editarItensLote(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	ids := idsSelecionados(r)
+
+	var erro, sucesso string
+	if r.Method == http.MethodPost && len(ids) == 0 {
+		erro = "No items selected"
+	} else if r.Method == http.MethodPost {
+		var err error
+		switch r.FormValue("acao") {
+		case "editar":
+			descricao := strings.TrimSpace(r.FormValue("descricao"))
+			acrescentar := strings.TrimSpace(r.FormValue("descricao_acrescentar"))
+			categoria := strings.TrimSpace(r.FormValue("categoria"))
+			adicionar := normalizarTags(r.FormValue("tags_adicionar"))
+			remover := normalizarTags(r.FormValue("tags_remover"))
+
+			if err = verificarCategoria(categoria); err != nil {
+				break
+			}
+			err = atualizarItensEmLote(ids, func(item *Item) {
+				if descricao != "" {
+					item.Descricao = descricao
+				}
+				if acrescentar != "" {
+					item.Descricao = strings.TrimSpace(item.Descricao + " " + acrescentar)
+				}
+				if categoria != "" {
+					item.Categoria = categoria
+				}
+				item.Tags = normalizarTags(strings.Join(append(item.Tags, adicionar...), ","))
+				if len(remover) > 0 {
+					var tags []string
+					for _, tag := range item.Tags {
+						if !contem(remover, tag) {
+							tags = append(tags, tag)
+						}
+					}
+					item.Tags = tags
+				}
+			})
+			if err == nil {
+				var mudancas []string
+				if descricao != "" {
+					mudancas = append(mudancas, "description="+descricao)
+				}
+				if acrescentar != "" {
+					mudancas = append(mudancas, "description+="+acrescentar)
+				}
+				if categoria != "" {
+					mudancas = append(mudancas, "category="+categoria)
+				}
+				if len(adicionar) > 0 {
+					mudancas = append(mudancas, "tags+="+strings.Join(adicionar, ","))
+				}
+				if len(remover) > 0 {
+					mudancas = append(mudancas, "tags-="+strings.Join(remover, ","))
+				}
+				registrarAuditoria(r, "edit", ids, strings.Join(mudancas, "; "))
+				sucesso = fmt.Sprintf("Updated %d item(s)", len(ids))
+			}
+		case "deletar":
+			err = deletarItensEmLote(ids)
+			if err == nil {
+				registrarAuditoria(r, "delete", ids, "")
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+		default:
+			err = fmt.Errorf("Unknown action")
+		}
+		if err != nil {
+			erro = err.Error()
+		}
+	}
+
+	var selecionados []Item
+	for _, id := range ids {
+		if i, ok := buscarItem(id); ok {
+			selecionados = append(selecionados, dados.Itens[i])
+		}
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/lote.html"))
+	tmpl.Execute(w, struct {
+		Itens   []Item
+		Error   string
+		Sucesso string
+		Config  Config
+	}{
+		Itens:   selecionados,
+		Error:   erro,
+		Sucesso: sucesso,
+		Config:  config,
+	})
+}
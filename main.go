@@ -1,19 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"html/template"
 	"image"
-	"image/jpeg"
-	"image/png"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -29,23 +27,37 @@ type User struct {
 }
 
 type Config struct {
-	Title            string `json:"title"`
-	ItemsPerPage     int    `json:"items_per_page"`
-	PhotoThumbSize   int    `json:"photo_thumbnail_size"`
-	PhotoPreviewSize int    `json:"photo_preview_size"`
-	SessionTimeout   int    `json:"session_timeout"`
-	MaxLoginAttempts int    `json:"max_login_attempts"`
-	LockoutDuration  int    `json:"lockout_duration"`
+	Title              string              `json:"title"`
+	ItemsPerPage       int                 `json:"items_per_page"`
+	PhotoThumbSize     int                 `json:"photo_thumbnail_size"`
+	PhotoPreviewSize   int                 `json:"photo_preview_size"`
+	SessionTimeout     int                 `json:"session_timeout"`
+	MaxLoginAttempts   int                 `json:"max_login_attempts"`
+	LockoutDuration    int                 `json:"lockout_duration"`
+	MaxAttachmentMB    int                 `json:"max_attachment_size_mb"`
+	MaxUploadMB        int                 `json:"max_upload_size_mb"`
+	MaxImageMegapixels int                 `json:"max_image_megapixels"`
+	PhotoStorage       ConfigArmazenamento `json:"photo_storage"`
+	PublicURL          string              `json:"public_url"` // base of the item URLs in QR codes
+	LabelPrinter       ConfigImpressora    `json:"label_printer"`
+	LowStockThreshold  int                 `json:"low_stock_threshold"` // units at or below which an item is low on stock
 }
 
 type Item struct {
-	ID            int    `json:"id"`
-	Nome          string `json:"nome"`
-	Descricao     string `json:"descricao"`
-	Estante       string `json:"estante"`
-	Prateleira    string `json:"prateleira"`
-	Compartimento string `json:"compartimento"`
-	Foto          string `json:"foto"`
+	ID            int               `json:"id"`
+	Nome          string            `json:"nome"`
+	Descricao     string            `json:"descricao"`
+	Estante       string            `json:"estante"`
+	Prateleira    string            `json:"prateleira"`
+	Compartimento string            `json:"compartimento"`
+	Quantidade    int               `json:"quantidade"` // units in stock
+	Foto          string            `json:"foto"`       // primary photo, always Fotos[0]
+	Fotos         []string          `json:"fotos,omitempty"`
+	Anexos        []Anexo           `json:"anexos,omitempty"`
+	Categoria     string            `json:"categoria"`
+	Tags          []string          `json:"tags"`
+	Campos        map[string]string `json:"campos,omitempty"`
+	Modificado    time.Time         `json:"modificado,omitzero"` // last change, zero until an older item is edited
 }
 
 type Estante struct {
@@ -57,9 +69,13 @@ type Rack struct {
 }
 
 type Inventario struct {
-	Itens    []Item    `json:"itens"`
-	Estantes []Estante `json:"estantes"`
-	Racks    []Rack    `json:"racks"`
+	Itens          []Item               `json:"itens"`
+	Estantes       []Estante            `json:"estantes"`
+	Racks          []Rack               `json:"racks"`
+	Compartimentos []Compartimento      `json:"compartimentos"`
+	Categorias     []Categoria          `json:"categorias"`
+	Campos         []CampoPersonalizado `json:"campos"`
+	ProximoID      int                  `json:"proximo_id"` // next item ID, see proximoIDItem
 }
 
 type PaginationData struct {
@@ -70,11 +86,13 @@ type PaginationData struct {
 }
 
 type Usuario struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Role     string `json:"role"`
-	Foto     string `json:"foto"`
+	ID           int                 `json:"id"`
+	Username     string              `json:"username"`
+	Password     string              `json:"password"`
+	Role         string              `json:"role"`
+	Foto         string              `json:"foto"`
+	Preferencias PreferenciasUsuario `json:"preferencias,omitzero"`
+	BuscasSalvas []BuscaSalva        `json:"buscas_salvas,omitempty"`
 }
 
 type UsuariosData struct {
@@ -95,13 +113,16 @@ func carregarConfig() {
 		json.Unmarshal(file, &config)
 	} else {
 		config = Config{
-			Title:            "Workshop Inventory",
-			ItemsPerPage:     10,
-			PhotoThumbSize:   100,
-			PhotoPreviewSize: 600,
-			SessionTimeout:   3600,
-			MaxLoginAttempts: 5,
-			LockoutDuration:  300,
+			Title:              "Workshop Inventory",
+			ItemsPerPage:       10,
+			PhotoThumbSize:     100,
+			PhotoPreviewSize:   600,
+			SessionTimeout:     3600,
+			MaxLoginAttempts:   5,
+			LockoutDuration:    300,
+			MaxAttachmentMB:    20,
+			MaxUploadMB:        50,
+			MaxImageMegapixels: 40,
 		}
 	}
 	// Initialize session store with a fixed secret key
@@ -112,12 +133,24 @@ func carregarDados() {
 	file, err := os.ReadFile("dados.json")
 	if err == nil {
 		json.Unmarshal(file, &dados)
+		migrarQuantidades(file)
 	}
+	iniciarProximoID()
+	migrarFotos()
+	reconstruirIndice()
 }
 
 func salvarDados() {
 	data, _ := json.MarshalIndent(dados, "", "  ")
-	ioutil.WriteFile("dados.json", data, 0644)
+	// Write to a temporary file and rename it so a crash never leaves a
+	// half-written dados.json behind
+	if err := ioutil.WriteFile("dados.json.tmp", data, 0644); err != nil {
+		log.Printf("Error saving data: %v", err)
+		return
+	}
+	if err := os.Rename("dados.json.tmp", "dados.json"); err != nil {
+		log.Printf("Error saving data: %v", err)
+	}
 }
 
 func carregarUsuarios() {
@@ -233,54 +266,85 @@ func generateThumbnail(img image.Image) image.Image {
 	return resize.Thumbnail(uint(config.PhotoThumbSize), uint(config.PhotoThumbSize), img, resize.Lanczos3)
 }
 
-func saveImage(file io.Reader, filename string) (string, error) {
-	// Create directories if they don't exist
-	os.MkdirAll("static/photos", 0755)
-	os.MkdirAll("static/photos/thumbs", 0755)
+func generatePreview(img image.Image) image.Image {
+	return resize.Thumbnail(uint(config.PhotoPreviewSize), uint(config.PhotoPreviewSize), img, resize.Lanczos3)
+}
+
+// saveImage stores an uploaded photo and its renditions under the SHA-256
+// of the stored image, so uploading the same photo twice keeps one file.
+func saveImage(file io.Reader) (string, error) {
+	return saveImageRecortada(file, Recorte{})
+}
 
-	// Read the image
-	img, format, err := image.Decode(file)
+// saveImageRecortada saves a photo after applying the rotation and crop
+// chosen by the user, so the thumbnail and preview show the same framing.
+func saveImageRecortada(file io.Reader, recorte Recorte) (string, error) {
+	// Read the image, rotated upright and without metadata
+	img, format, err := decodificarFoto(file)
 	if err != nil {
 		return "", err
 	}
+	img = aplicarRecorte(img, recorte)
+	var buf bytes.Buffer
+	if err := codificarImagem(&buf, img, format); err != nil {
+		return "", err
+	}
+	soma := sha256.Sum256(buf.Bytes())
+	filename := hex.EncodeToString(soma[:]) + extensaoFormato(format)
 
-	// Generate thumbnail
-	thumb := generateThumbnail(img)
-
-	// Save original image
-	originalPath := filepath.Join("static/photos", filename)
-	f, err := os.Create(originalPath)
+	// Save original image unless an identical one is already stored
+	existe, err := armazenamento.Existe(filename)
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
-
-	// Save based on format
-	switch format {
-	case "jpeg", "jpg":
-		jpeg.Encode(f, img, nil)
-	case "png":
-		png.Encode(f, img)
-	default:
-		return "", fmt.Errorf("unsupported format: %s", format)
+	if !existe {
+		if err := armazenamento.Salvar(filename, buf.Bytes()); err != nil {
+			return "", err
+		}
 	}
 
-	// Save thumbnail
-	thumbPath := filepath.Join("static/photos/thumbs", filename)
-	f, err = os.Create(thumbPath)
-	if err != nil {
+	// Save thumbnail and preview
+	if err := salvarVersoes(img, filename); err != nil {
 		return "", err
 	}
-	defer f.Close()
-	jpeg.Encode(f, thumb, nil)
 
 	return filename, nil
 }
 
 func main() {
 	carregarConfig()
+	if err := iniciarArmazenamento(); err != nil {
+		log.Fatalf("Error configuring photo storage: %v", err)
+	}
 	carregarDados()
 	carregarUsuarios()
+	carregarAuditoria()
+	moverAnexosAntigos()
+
+	// Maintenance commands run once and exit
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "regenerate-renditions":
+			total, err := regenerarVersoes()
+			if err != nil {
+				log.Fatalf("Error regenerating renditions: %v", err)
+			}
+			log.Printf("Regenerated renditions for %d photos", total)
+			return
+		case "gc-photos":
+			limparFotos(len(os.Args) > 2 && os.Args[2] == "--delete")
+			return
+		case "migrate-photos":
+			total, err := migrarArmazenamento()
+			if err != nil {
+				log.Fatalf("Error migrating photos: %v", err)
+			}
+			log.Printf("Copied %d photo files to the photo storage", total)
+			return
+		}
+	}
+
+	validarFotos()
 
 	// Create template functions
 	funcMap := template.FuncMap{
@@ -305,6 +369,7 @@ func main() {
 	http.HandleFunc("/login", login)
 	http.HandleFunc("/logout", logout)
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	http.HandleFunc("/static/photos/", servirFoto)
 
 	// Protected routes
 	http.HandleFunc("/", requireAuth(func(w http.ResponseWriter, r *http.Request) {
@@ -321,6 +386,50 @@ func main() {
 	http.HandleFunc("/racks/novo", requireRole("admin", novoRack))
 	http.HandleFunc("/racks/editar", requireRole("admin", editarRack))
 	http.HandleFunc("/racks/deletar", requireRole("admin", deletarRack))
+	http.HandleFunc("/racks/ocupacao", requireRole("admin", ocupacaoRack))
+	http.HandleFunc("/categorias", requireRole("admin", listarCategorias))
+	http.HandleFunc("/categorias/novo", requireRole("admin", novaCategoria))
+	http.HandleFunc("/categorias/editar", requireRole("admin", editarCategoria))
+	http.HandleFunc("/categorias/deletar", requireRole("admin", deletarCategoria))
+	http.HandleFunc("/tags", requireRole("admin", listarTags))
+	http.HandleFunc("/tags/editar", requireRole("admin", editarTag))
+	http.HandleFunc("/tags/deletar", requireRole("admin", deletarTag))
+	http.HandleFunc("/campos", requireRole("admin", listarCampos))
+	http.HandleFunc("/campos/novo", requireRole("admin", novoCampo))
+	http.HandleFunc("/campos/editar", requireRole("admin", editarCampo))
+	http.HandleFunc("/campos/deletar", requireRole("admin", deletarCampo))
+	http.HandleFunc("/item", requireAuth(verItem))
+	http.HandleFunc("/itens/sugestoes", requireAuth(sugerirBusca))
+	http.HandleFunc("/itens/buscar", requireAuth(buscarItensJSON))
+	http.HandleFunc("/preferencias", requireAuth(editarPreferencias))
+	http.HandleFunc("/preferencias/vista", requireAuth(alternarVista))
+	http.HandleFunc("/buscas/salvar", requireAuth(salvarBusca))
+	http.HandleFunc("/buscas/deletar", requireAuth(deletarBusca))
+	http.HandleFunc("/codigo", requireAuth(servirCodigo))
+	http.HandleFunc("/scan", requireAuth(escanear))
+	http.HandleFunc("/scan/resolver", requireAuth(resolverCodigoJSON))
+	http.HandleFunc("/scan/acao", requireRole("admin", acaoEscanear))
+	http.HandleFunc("/etiquetas", requireRole("admin", listarEtiquetas))
+	http.HandleFunc("/etiquetas/pdf", requireRole("admin", baixarEtiquetasPDF))
+	http.HandleFunc("/etiquetas/zpl", requireRole("admin", baixarEtiquetasZPL))
+	http.HandleFunc("/etiquetas/imprimir", requireRole("admin", imprimirEtiquetas))
+	http.HandleFunc("/itens/fotos", requireRole("admin", organizarFotos))
+	http.HandleFunc("/itens/fotos/recortar", requireRole("admin", recortarFoto))
+	http.HandleFunc("/fotos", requireRole("admin", verificarArmazenamento))
+	http.HandleFunc("/fotos/limpar", requireRole("admin", limparArmazenamento))
+	http.HandleFunc("/itens/anexo", requireAuth(baixarAnexo))
+	http.HandleFunc("/itens/anexos/deletar", requireRole("admin", deletarAnexo))
+	http.HandleFunc("/mapa", requireAuth(mapaOficina))
+	http.HandleFunc("/itens/mover", requireRole("admin", moverItem))
+	http.HandleFunc("/itens/mover-lote", requireRole("admin", moverItensLote))
+	http.HandleFunc("/itens/lote", requireRole("admin", editarItensLote))
+	http.HandleFunc("/itens/exportar", requireRole("admin", exportarSelecao))
+	http.HandleFunc("/itens/importar", requireRole("admin", importarItens))
+	http.HandleFunc("/itens/exportar-lista", requireRole("admin", exportarLista))
+	http.HandleFunc("/compartimentos", requireRole("admin", listarCompartimentos))
+	http.HandleFunc("/compartimentos/novo", requireRole("admin", novoCompartimento))
+	http.HandleFunc("/compartimentos/editar", requireRole("admin", editarCompartimento))
+	http.HandleFunc("/compartimentos/deletar", requireRole("admin", deletarCompartimento))
 
 	// Add user management routes
 	http.HandleFunc("/usuarios", listarUsuarios)
@@ -333,31 +442,45 @@ func main() {
 }
 
 func listarItens(w http.ResponseWriter, r *http.Request, tmpl *template.Template) {
-	busca := strings.TrimSpace(strings.ToLower(r.URL.Query().Get("q")))
+	busca := strings.TrimSpace(r.URL.Query().Get("q"))
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	if page < 1 {
 		page = 1
 	}
 
-	var itensFiltrados []Item
-	if busca != "" {
-		for _, item := range dados.Itens {
-			if strings.Contains(strings.ToLower(item.Nome), busca) || strings.Contains(strings.ToLower(item.Descricao), busca) {
-				itensFiltrados = append(itensFiltrados, item)
-			}
-		}
-	} else {
-		itensFiltrados = dados.Itens
+	var erroBusca string
+	itensFiltrados, aproximada, err := itensDaBusca(busca)
+	if err != nil {
+		erroBusca = err.Error()
 	}
 
-	// Sort items by ID descending (newest first)
-	sort.Slice(itensFiltrados, func(i, j int) bool {
-		return itensFiltrados[i].ID > itensFiltrados[j].ID
-	})
+	// Filter and count the facets in one pass
+	categoria := r.URL.Query().Get("categoria")
+	tag := normalizarTag(r.URL.Query().Get("tag"))
+	estante := r.URL.Query().Get("estante")
+	rack := r.URL.Query().Get("rack")
+	dimensoes := dimensoesFaceta()
+	itensFiltrados, contagens := agregarFacetas(itensFiltrados, filtrosLista(r.URL.Query()), dimensoes)
+
+	preferencias := preferenciasDaSessao(r)
+	ordem := ordemDaLista(r)
+	ordenarItens(itensFiltrados, ordem)
+
+	// Everything but the page, for saved searches, and without the search
+	// for pagination links
+	estado := estadoLista(r.URL.Query())
+	filtros := estadoLista(r.URL.Query())
+	filtros.Del("q")
+	facetas := montarFacetas(dimensoes, contagens, estado)
+
+	itensPorPagina := config.ItemsPerPage
+	if preferencias.ItensPorPagina > 0 {
+		itensPorPagina = preferencias.ItensPorPagina
+	}
 
 	// Calculate pagination
 	totalItems := len(itensFiltrados)
-	totalPages := (totalItems + config.ItemsPerPage - 1) / config.ItemsPerPage
+	totalPages := (totalItems + itensPorPagina - 1) / itensPorPagina
 	if totalPages == 0 {
 		totalPages = 1
 	}
@@ -366,8 +489,8 @@ func listarItens(w http.ResponseWriter, r *http.Request, tmpl *template.Template
 	}
 
 	// Get items for current page
-	startIndex := (page - 1) * config.ItemsPerPage
-	endIndex := startIndex + config.ItemsPerPage
+	startIndex := (page - 1) * itensPorPagina
+	endIndex := startIndex + itensPorPagina
 	if endIndex > totalItems {
 		endIndex = totalItems
 	}
@@ -377,6 +500,17 @@ func listarItens(w http.ResponseWriter, r *http.Request, tmpl *template.Template
 		pageItems = itensFiltrados[startIndex:endIndex]
 	}
 
+	// Nothing found: offer the query with its typos corrected
+	var sugestoes []string
+	if busca != "" && totalItems == 0 && erroBusca == "" {
+		sugestoes = sugerirConsultas(busca)
+	}
+
+	var buscasSalvas []BuscaSalva
+	if u := usuarioDaSessao(r); u != nil {
+		buscasSalvas = u.BuscasSalvas
+	}
+
 	session, _ := store.Get(r, "session")
 	username, _ := session.Values["username"].(string)
 	role, _ := session.Values["role"].(string)
@@ -384,19 +518,55 @@ func listarItens(w http.ResponseWriter, r *http.Request, tmpl *template.Template
 	tmpl.ExecuteTemplate(w, "index.html", struct {
 		Itens      []Item
 		Estantes   []Estante
+		Categorias []CategoriaArvore
+		Tags       []string
+		Racks      []Rack
+		Ordens     []OrdemItens
+		Categoria  string
+		Tag        string
+		Estante    string
+		Rack       string
+		Ordem      string
+		Filtros    template.URL
+		Estado     string
+		Vista      string
+		Buscas     []BuscaSalva
+		Facetas    []Faceta
+		Voltar     string
 		Query      string
+		Aproximada bool
+		Sugestoes  []string
+		ErroBusca  string
 		Pagination PaginationData
 		Config     Config
 		Username   string
 		Role       string
 	}{
-		Itens:    pageItems,
-		Estantes: dados.Estantes,
-		Query:    r.URL.Query().Get("q"),
+		Itens:      pageItems,
+		Estantes:   dados.Estantes,
+		Categorias: arvoreCategorias(),
+		Tags:       nomesTags(),
+		Racks:      dados.Racks,
+		Ordens:     ordensItens,
+		Categoria:  categoria,
+		Tag:        tag,
+		Estante:    estante,
+		Rack:       rack,
+		Ordem:      ordem,
+		Filtros:    template.URL(filtros.Encode()),
+		Estado:     estado.Encode(),
+		Vista:      preferencias.Vista,
+		Buscas:     buscasSalvas,
+		Facetas:    facetas,
+		Voltar:     r.URL.RequestURI(),
+		Query:      r.URL.Query().Get("q"),
+		Aproximada: aproximada && totalItems > 0,
+		Sugestoes:  sugestoes,
+		ErroBusca:  erroBusca,
 		Pagination: PaginationData{
 			CurrentPage:  page,
 			TotalPages:   totalPages,
-			ItemsPerPage: config.ItemsPerPage,
+			ItemsPerPage: itensPorPagina,
 			TotalItems:   totalItems,
 		},
 		Config:   config,
@@ -405,72 +575,120 @@ func listarItens(w http.ResponseWriter, r *http.Request, tmpl *template.Template
 	})
 }
 
+func renderNovoItem(w http.ResponseWriter, item Item, erro string) {
+	tmpl := template.Must(template.ParseFiles("templates/novo_item.html", "templates/campos_item.html", "templates/recorte.html"))
+	tmpl.Execute(w, struct {
+		Error      string
+		Item       Item
+		Estantes   []Estante
+		Racks      []Rack
+		Categorias []CategoriaArvore
+		Tags       []string
+		Campos     []CampoForm
+		Config     Config
+	}{
+		Error:      erro,
+		Item:       item,
+		Estantes:   dados.Estantes,
+		Racks:      dados.Racks,
+		Categorias: arvoreCategorias(),
+		Tags:       nomesTags(),
+		Campos:     montarCamposForm(item),
+		Config:     config,
+	})
+}
+
+// itemDoFormulario fills an item with the values of the item form as
+// sent, to show the form again when they are rejected.
+func itemDoFormulario(r *http.Request) Item {
+	quantidade, _ := quantidadeDoForm(r)
+	valores := map[string]string{}
+	for _, c := range dados.Campos {
+		valores[c.Nome] = r.FormValue("campo_" + c.Nome)
+	}
+	return Item{
+		Nome:          r.FormValue("nome"),
+		Descricao:     r.FormValue("descricao"),
+		Estante:       r.FormValue("estante"),
+		Prateleira:    r.FormValue("prateleira"),
+		Compartimento: r.FormValue("compartimento"),
+		Quantidade:    quantidade,
+		Categoria:     r.FormValue("categoria"),
+		Tags:          normalizarTags(r.FormValue("tags")),
+		Campos:        valores,
+	}
+}
+
 func novoItem(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		tmpl := template.Must(template.ParseFiles("templates/novo_item.html"))
-		tmpl.Execute(w, struct {
-			Error    string
-			Item     Item
-			Estantes []Estante
-			Racks    []Rack
-			Config   Config
-		}{
-			Estantes: dados.Estantes,
-			Racks:    dados.Racks,
-			Config:   config,
-		})
+		// Pre-fill the location when coming from an empty slot on the map
+		renderNovoItem(w, Item{
+			Estante:       r.URL.Query().Get("estante"),
+			Prateleira:    r.URL.Query().Get("prateleira"),
+			Compartimento: r.URL.Query().Get("compartimento"),
+			Quantidade:    1,
+		}, "")
 		return
 	}
 
 	if r.Method == http.MethodPost {
-		r.ParseMultipartForm(10 << 20) // 10MB max memory
+		if err := lerFormularioUpload(w, r); err != nil {
+			renderNovoItem(w, itemDoFormulario(r), err.Error())
+			return
+		}
 
 		// Check for duplicate location
 		estante := r.FormValue("estante")
 		prateleira := r.FormValue("prateleira")
 		compartimento := r.FormValue("compartimento")
 
-		for _, item := range dados.Itens {
-			if item.Estante == estante && item.Prateleira == prateleira && item.Compartimento == compartimento {
-				// Return to the form with error message
-				tmpl := template.Must(template.ParseFiles("templates/novo_item.html"))
-				tmpl.Execute(w, struct {
-					Error    string
-					Item     Item
-					Estantes []Estante
-					Racks    []Rack
-					Config   Config
-				}{
-					Error: fmt.Sprintf("An item already exists in this location (Shelf: %s, Rack: %s, Compartment: %s)", estante, prateleira, compartimento),
-					Item: Item{
-						Nome:          r.FormValue("nome"),
-						Descricao:     r.FormValue("descricao"),
-						Estante:       estante,
-						Prateleira:    prateleira,
-						Compartimento: compartimento,
-					},
-					Estantes: dados.Estantes,
-					Racks:    dados.Racks,
-					Config:   config,
-				})
-				return
-			}
+		categoria := r.FormValue("categoria")
+		tags := normalizarTags(r.FormValue("tags"))
+		quantidade, errQuantidade := quantidadeDoForm(r)
+
+		err := validarLocalizacao(prateleira, estante, compartimento, 0)
+		if err == nil {
+			err = verificarCategoria(categoria)
+		}
+		campos, errCampos := camposDoForm(r, categoria)
+		if err == nil {
+			err = errCampos
+		}
+		if err == nil {
+			err = errQuantidade
+		}
+		if err != nil {
+			// Return to the form with error message
+			renderNovoItem(w, itemDoFormulario(r), err.Error())
+			return
 		}
 
-		file, header, err := r.FormFile("foto")
-		var filename string
+		fotos, err := salvarFotosDoForm(r, "foto")
+		var anexos []Anexo
 		if err == nil {
-			defer file.Close()
-			ext := filepath.Ext(header.Filename)
-			filename = fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-			filename, err = saveImage(file, filename)
+			anexos, err = salvarAnexosDoForm(r, "anexos")
 			if err != nil {
-				log.Printf("Error saving image: %v", err)
-				filename = ""
+				for _, f := range fotos {
+					removerFoto(f)
+				}
 			}
 		}
+		if err != nil {
+			renderNovoItem(w, Item{
+				Nome:          r.FormValue("nome"),
+				Descricao:     r.FormValue("descricao"),
+				Estante:       estante,
+				Prateleira:    prateleira,
+				Compartimento: compartimento,
+				Quantidade:    quantidade,
+				Categoria:     categoria,
+				Tags:          tags,
+				Campos:        campos,
+			}, err.Error())
+			return
+		}
 
-		id := len(dados.Itens) + 1
+		id := proximoIDItem()
 		item := Item{
 			ID:            id,
 			Nome:          r.FormValue("nome"),
@@ -478,14 +696,41 @@ func novoItem(w http.ResponseWriter, r *http.Request) {
 			Estante:       estante,
 			Prateleira:    prateleira,
 			Compartimento: compartimento,
-			Foto:          filename,
+			Quantidade:    quantidade,
+			Fotos:         fotos,
+			Anexos:        anexos,
+			Categoria:     categoria,
+			Tags:          tags,
+			Campos:        campos,
+			Modificado:    time.Now(),
 		}
+		atualizarFotoPrincipal(&item)
 		dados.Itens = append(dados.Itens, item)
+		indexarItem(item)
 		salvarDados()
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
 
+func renderEditarItem(w http.ResponseWriter, item Item, erro string) {
+	tmpl := template.Must(template.ParseFiles("templates/editar.html", "templates/campos_item.html", "templates/recorte.html"))
+	tmpl.Execute(w, struct {
+		Error      string
+		Item       Item
+		Categorias []CategoriaArvore
+		Tags       []string
+		Campos     []CampoForm
+		Config     Config
+	}{
+		Error:      erro,
+		Item:       item,
+		Categorias: arvoreCategorias(),
+		Tags:       nomesTags(),
+		Campos:     montarCamposForm(item),
+		Config:     config,
+	})
+}
+
 func editarItem(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
@@ -497,19 +742,13 @@ func editarItem(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		tmpl := template.Must(template.ParseFiles("templates/editar.html"))
-		tmpl.Execute(w, struct {
-			Item   Item
-			Config Config
-		}{
-			Item:   item,
-			Config: config,
-		})
+		renderEditarItem(w, item, "")
 		return
 	}
 
 	if r.Method == http.MethodPost {
-		r.ParseMultipartForm(10 << 20) // 10MB max memory
+		// The id is also in the URL so a rejected upload can show the form again
+		erroUpload := lerFormularioUpload(w, r)
 
 		id, _ := strconv.Atoi(r.FormValue("id"))
 		var itemIndex int
@@ -523,50 +762,96 @@ func editarItem(w http.ResponseWriter, r *http.Request) {
 				break
 			}
 		}
+		if erroUpload != nil {
+			renderEditarItem(w, currentItem, erroUpload.Error())
+			return
+		}
 
 		// Check for duplicate location (excluding current item)
 		estante := r.FormValue("estante")
 		prateleira := r.FormValue("prateleira")
 		compartimento := r.FormValue("compartimento")
+		categoria := r.FormValue("categoria")
 
-		for i, item := range dados.Itens {
-			if i != itemIndex && item.Estante == estante && item.Prateleira == prateleira && item.Compartimento == compartimento {
-				http.Error(w, "An item already exists in this location (Shelf: "+estante+", Rack: "+prateleira+", Compartment: "+compartimento+")", http.StatusBadRequest)
-				return
+		// Return to the form with the submitted values on error
+		erroForm := func(err error) {
+			valores := map[string]string{}
+			for _, c := range dados.Campos {
+				valores[c.Nome] = r.FormValue("campo_" + c.Nome)
 			}
+			item := currentItem
+			item.Nome = r.FormValue("nome")
+			item.Descricao = r.FormValue("descricao")
+			item.Estante = estante
+			item.Prateleira = prateleira
+			item.Compartimento = compartimento
+			item.Quantidade, _ = strconv.Atoi(r.FormValue("quantidade"))
+			item.Categoria = categoria
+			item.Tags = normalizarTags(r.FormValue("tags"))
+			item.Campos = valores
+			renderEditarItem(w, item, err.Error())
 		}
 
-		// Handle photo upload
-		file, header, err := r.FormFile("foto")
-		filename := currentItem.Foto // Keep current photo by default
-		if err == nil {
-			// New photo uploaded
-			defer file.Close()
-			// Delete old photo if exists
-			if currentItem.Foto != "" {
-				os.Remove(filepath.Join("static/photos", currentItem.Foto))
-				os.Remove(filepath.Join("static/photos/thumbs", currentItem.Foto))
-			}
-			// Save new photo
-			ext := filepath.Ext(header.Filename)
-			filename = fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-			filename, err = saveImage(file, filename)
-			if err != nil {
-				log.Printf("Error saving image: %v", err)
-				filename = currentItem.Foto // Keep old photo on error
+		if err := validarLocalizacao(prateleira, estante, compartimento, id); err != nil {
+			erroForm(err)
+			return
+		}
+
+		if err := verificarCategoria(categoria); err != nil {
+			erroForm(err)
+			return
+		}
+
+		campos, err := camposDoForm(r, categoria)
+		if err != nil {
+			erroForm(err)
+			return
+		}
+		quantidade, err := quantidadeDoForm(r)
+		if err != nil {
+			erroForm(err)
+			return
+		}
+
+		// Handle photo and attachment uploads
+		fotos, err := salvarFotosDoForm(r, "foto")
+		if err != nil {
+			erroForm(err)
+			return
+		}
+		anexos, err := salvarAnexosDoForm(r, "anexos")
+		if err != nil {
+			for _, f := range fotos {
+				removerFoto(f)
 			}
+			erroForm(err)
+			return
 		}
 
-		// Update item
-		dados.Itens[itemIndex] = Item{
+		// Update item, adding new photos to the end of the gallery
+		for _, f := range fotos {
+			if !contem(currentItem.Fotos, f) {
+				currentItem.Fotos = append(currentItem.Fotos, f)
+			}
+		}
+		item := Item{
 			ID:            id,
 			Nome:          r.FormValue("nome"),
 			Descricao:     r.FormValue("descricao"),
 			Estante:       estante,
 			Prateleira:    prateleira,
 			Compartimento: compartimento,
-			Foto:          filename,
+			Quantidade:    quantidade,
+			Fotos:         currentItem.Fotos,
+			Anexos:        append(currentItem.Anexos, anexos...),
+			Categoria:     categoria,
+			Tags:          normalizarTags(r.FormValue("tags")),
+			Campos:        campos,
+			Modificado:    time.Now(),
 		}
+		atualizarFotoPrincipal(&item)
+		dados.Itens[itemIndex] = item
+		indexarItem(item)
 
 		salvarDados()
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -578,10 +863,12 @@ func deletarItem(w http.ResponseWriter, r *http.Request) {
 	for i, item := range dados.Itens {
 		if item.ID == id {
 			dados.Itens = append(dados.Itens[:i], dados.Itens[i+1:]...)
+			removerDoIndice(id)
+			salvarDados()
+			removerArquivosItem(item)
 			break
 		}
 	}
-	salvarDados()
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -607,6 +894,13 @@ func deletarEstante(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 	}
+	var compartimentos []Compartimento
+	for _, c := range dados.Compartimentos {
+		if c.Estante != nome {
+			compartimentos = append(compartimentos, c)
+		}
+	}
+	dados.Compartimentos = compartimentos
 	salvarDados()
 	http.Redirect(w, r, "/estantes", http.StatusSeeOther)
 }
@@ -629,6 +923,12 @@ func editarEstante(w http.ResponseWriter, r *http.Request) {
 		for i, item := range dados.Itens {
 			if item.Estante == nomeAntigo {
 				dados.Itens[i].Estante = nomeNovo
+				dados.Itens[i].Modificado = time.Now()
+			}
+		}
+		for i, c := range dados.Compartimentos {
+			if c.Estante == nomeAntigo {
+				dados.Compartimentos[i].Estante = nomeNovo
 			}
 		}
 
@@ -650,6 +950,11 @@ func listarUsuarios(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	renderUsuarios(w, r, "")
+}
+
+func renderUsuarios(w http.ResponseWriter, r *http.Request, erro string) {
+	session, _ := store.Get(r, "session")
 	tmpl := template.Must(template.ParseFiles("templates/usuarios.html"))
 	tmpl.Execute(w, struct {
 		Usuarios []Usuario
@@ -659,9 +964,10 @@ func listarUsuarios(w http.ResponseWriter, r *http.Request) {
 		Role     string
 	}{
 		Usuarios: usuariosData.Usuarios,
+		Error:    erro,
 		Config:   config,
 		Username: session.Values["username"].(string),
-		Role:     role,
+		Role:     getUserRole(r),
 	})
 }
 
@@ -679,7 +985,10 @@ func novoUsuario(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodPost {
-		r.ParseMultipartForm(10 << 20) // 10MB max memory
+		if err := lerFormularioUpload(w, r); err != nil {
+			renderUsuarios(w, r, err.Error())
+			return
+		}
 
 		username := r.FormValue("username")
 		password := r.FormValue("password")
@@ -688,20 +997,7 @@ func novoUsuario(w http.ResponseWriter, r *http.Request) {
 		// Check if username already exists
 		for _, user := range usuariosData.Usuarios {
 			if user.Username == username {
-				tmpl := template.Must(template.ParseFiles("templates/usuarios.html"))
-				tmpl.Execute(w, struct {
-					Usuarios []Usuario
-					Error    string
-					Config   Config
-					Username string
-					Role     string
-				}{
-					Usuarios: usuariosData.Usuarios,
-					Error:    "Username already exists",
-					Config:   config,
-					Username: session.Values["username"].(string),
-					Role:     role,
-				})
+				renderUsuarios(w, r, "Username already exists")
 				return
 			}
 		}
@@ -711,12 +1007,11 @@ func novoUsuario(w http.ResponseWriter, r *http.Request) {
 		var filename string
 		if err == nil {
 			defer file.Close()
-			ext := filepath.Ext(header.Filename)
-			filename = fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-			filename, err = saveImage(file, filename)
+			filename, err = saveImage(file)
 			if err != nil {
 				log.Printf("Error saving image: %v", err)
-				filename = ""
+				renderUsuarios(w, r, header.Filename+": "+err.Error())
+				return
 			}
 		}
 
@@ -748,7 +1043,10 @@ func editarUsuario(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodPost {
-		r.ParseMultipartForm(10 << 20) // 10MB max memory
+		if err := lerFormularioUpload(w, r); err != nil {
+			renderUsuarios(w, r, err.Error())
+			return
+		}
 
 		id, _ := strconv.Atoi(r.FormValue("id"))
 		username := r.FormValue("username")
@@ -774,30 +1072,29 @@ func editarUsuario(w http.ResponseWriter, r *http.Request) {
 				if err == nil {
 					// New photo uploaded
 					defer file.Close()
-					// Delete old photo if exists
-					if user.Foto != "" {
-						os.Remove(filepath.Join("static/photos", user.Foto))
-						os.Remove(filepath.Join("static/photos/thumbs", user.Foto))
-					}
-					// Save new photo
-					ext := filepath.Ext(header.Filename)
-					filename = fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-					filename, err = saveImage(file, filename)
+					filename, err = saveImage(file)
 					if err != nil {
 						log.Printf("Error saving image: %v", err)
-						filename = user.Foto // Keep old photo on error
+						renderUsuarios(w, r, header.Filename+": "+err.Error())
+						return
 					}
 				}
 
 				// Update user
 				usuariosData.Usuarios[i] = Usuario{
-					ID:       id,
-					Username: username,
-					Password: password,
-					Role:     role,
-					Foto:     filename,
+					ID:           id,
+					Username:     username,
+					Password:     password,
+					Role:         role,
+					Foto:         filename,
+					Preferencias: user.Preferencias,
+					BuscasSalvas: user.BuscasSalvas,
 				}
 				salvarUsuarios()
+				// Delete the old photo if the user no longer uses it
+				if user.Foto != filename {
+					removerFoto(user.Foto)
+				}
 				http.Redirect(w, r, "/usuarios", http.StatusSeeOther)
 				return
 			}
@@ -822,13 +1119,10 @@ func deletarUsuario(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
 	for i, user := range usuariosData.Usuarios {
 		if user.ID == id {
-			// Delete user's photo if exists
-			if user.Foto != "" {
-				os.Remove(filepath.Join("static/photos", user.Foto))
-				os.Remove(filepath.Join("static/photos/thumbs", user.Foto))
-			}
 			usuariosData.Usuarios = append(usuariosData.Usuarios[:i], usuariosData.Usuarios[i+1:]...)
 			salvarUsuarios()
+			// Delete user's photo if exists
+			removerFoto(user.Foto)
 			http.Redirect(w, r, "/usuarios", http.StatusSeeOther)
 			return
 		}
@@ -858,6 +1152,13 @@ func deletarRack(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 	}
+	var compartimentos []Compartimento
+	for _, c := range dados.Compartimentos {
+		if c.Prateleira != nome {
+			compartimentos = append(compartimentos, c)
+		}
+	}
+	dados.Compartimentos = compartimentos
 	salvarDados()
 	http.Redirect(w, r, "/racks", http.StatusSeeOther)
 }
@@ -880,6 +1181,12 @@ func editarRack(w http.ResponseWriter, r *http.Request) {
 		for i, item := range dados.Itens {
 			if item.Prateleira == nomeAntigo {
 				dados.Itens[i].Prateleira = nomeNovo
+				dados.Itens[i].Modificado = time.Now()
+			}
+		}
+		for i, c := range dados.Compartimentos {
+			if c.Prateleira == nomeAntigo {
+				dados.Compartimentos[i].Prateleira = nomeNovo
 			}
 		}
 
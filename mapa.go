@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type GradeRack struct {
+	Rack   string
+	Linhas []LinhaGrade
+}
+
+// racksEmUso lists the registered racks followed by any rack that items or
+// compartments reference without being registered.
+func racksEmUso() []string {
+	var racks []string
+	vistos := map[string]bool{}
+	adicionar := func(nome string) {
+		if nome != "" && !vistos[nome] {
+			vistos[nome] = true
+			racks = append(racks, nome)
+		}
+	}
+	for _, rack := range dados.Racks {
+		adicionar(rack.Nome)
+	}
+	for _, item := range dados.Itens {
+		adicionar(item.Prateleira)
+	}
+	for _, c := range dados.Compartimentos {
+		adicionar(c.Prateleira)
+	}
+	return racks
+}
+
+func mapaOficina(w http.ResponseWriter, r *http.Request) {
+	var grades []GradeRack
+	for _, rack := range racksEmUso() {
+		grades = append(grades, GradeRack{Rack: rack, Linhas: montarGrade(rack)})
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/mapa.html"))
+	tmpl.Execute(w, struct {
+		Grades []GradeRack
+		Config Config
+		Role   string
+	}{
+		Grades: grades,
+		Config: config,
+		Role:   getUserRole(r),
+	})
+}
+
+// moverItem relocates a single item and answers with the updated item as
+// JSON, so the map page can move it without reloading.
+func moverItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	prateleira := r.FormValue("prateleira")
+	estante := r.FormValue("estante")
+	compartimento := r.FormValue("compartimento")
+
+	for i, item := range dados.Itens {
+		if item.ID == id {
+			if err := validarLocalizacao(prateleira, estante, compartimento, id); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			dados.Itens[i].Prateleira = prateleira
+			dados.Itens[i].Estante = estante
+			dados.Itens[i].Compartimento = compartimento
+			dados.Itens[i].Modificado = time.Now()
+			salvarDados()
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(dados.Itens[i])
+			return
+		}
+	}
+	http.Error(w, "Item not found", http.StatusNotFound)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OrdemItens is a way of sorting the item list. A "-" in front of Valor
+// reverses it.
+type OrdemItens struct {
+	Valor string
+	Nome  string
+}
+
+// ordensItens are the sort orders offered on the item list, after the
+// default of best match first when searching and newest first otherwise.
+var ordensItens = []OrdemItens{
+	{"nome", "Name A–Z"},
+	{"-nome", "Name Z–A"},
+	{"local", "Location"},
+	{"-local", "Location, reversed"},
+	{"quantidade", "Quantity, lowest first"},
+	{"-quantidade", "Quantity, highest first"},
+	{"-modificado", "Recently modified"},
+	{"modificado", "Least recently modified"},
+}
+
+func ordemValida(ordem string) bool {
+	for _, o := range ordensItens {
+		if o.Valor == ordem {
+			return true
+		}
+	}
+	return false
+}
+
+// ordenarItens sorts the items in place. Items that compare equal keep their
+// order, so a sorted search still lists the best matches first among them.
+func ordenarItens(itens []Item, ordem string) {
+	campo := strings.TrimPrefix(ordem, "-")
+	inverter := strings.HasPrefix(ordem, "-")
+
+	var comparar func(a, b Item) int
+	switch campo {
+	case "nome":
+		comparar = func(a, b Item) int {
+			return strings.Compare(normalizarTexto(a.Nome), normalizarTexto(b.Nome))
+		}
+	case "local":
+		comparar = func(a, b Item) int {
+			if c := compararNatural(a.Prateleira, b.Prateleira); c != 0 {
+				return c
+			}
+			if c := compararNatural(a.Estante, b.Estante); c != 0 {
+				return c
+			}
+			return compararNatural(a.Compartimento, b.Compartimento)
+		}
+	case "quantidade":
+		comparar = func(a, b Item) int { return a.Quantidade - b.Quantidade }
+	case "modificado":
+		comparar = func(a, b Item) int { return a.Modificado.Compare(b.Modificado) }
+	default:
+		return
+	}
+	sort.SliceStable(itens, func(i, j int) bool {
+		if inverter {
+			return comparar(itens[j], itens[i]) < 0
+		}
+		return comparar(itens[i], itens[j]) < 0
+	})
+}
+
+// compararNatural compares location names so that compartment "2" comes
+// before "10", comparing runs of digits by their value.
+func compararNatural(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		na, ra := prefixoNumerico(a)
+		nb, rb := prefixoNumerico(b)
+		if na != "" && nb != "" {
+			x, _ := strconv.Atoi(na)
+			y, _ := strconv.Atoi(nb)
+			if x != y {
+				return x - y
+			}
+			a, b = ra, rb
+			continue
+		}
+		if a[0] != b[0] {
+			return int(a[0]) - int(b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) - len(b)
+}
+
+func prefixoNumerico(s string) (numero, resto string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i], s[i:]
+}
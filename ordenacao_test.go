@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompararNatural(t *testing.T) {
+	testes := []struct {
+		a, b  string
+		sinal int
+	}{
+		{"", "", 0},
+		{"", "a", -1},
+		{"a", "", 1},
+		{"2", "10", -1},
+		{"10", "2", 1},
+		{"10", "10", 0},
+		{"L1", "L2", -1},
+		{"L2", "L10", -1},
+		{"l1", "L1", 0},
+		{"P-0001", "P-0002", -1},
+		{"P-0010", "P-0002", 1},
+		{"P-001", "P-1", 0}, // leading zeros do not count
+		{"a", "B", -1},
+		{"B2", "a10", 1},
+		{"a", "a1", -1},
+		{"1a", "1b", -1},
+		{"x9y", "x10y", -1},
+		{"x10y", "x10z", -1},
+	}
+	for _, tt := range testes {
+		got := compararNatural(tt.a, tt.b)
+		if sinal(got) != tt.sinal {
+			t.Errorf("compararNatural(%q, %q) = %d, want sign %d", tt.a, tt.b, got, tt.sinal)
+		}
+		if sinal(compararNatural(tt.b, tt.a)) != -tt.sinal {
+			t.Errorf("compararNatural(%q, %q) is not the opposite of (%q, %q)", tt.b, tt.a, tt.a, tt.b)
+		}
+	}
+}
+
+func sinal(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestOrdenarItens(t *testing.T) {
+	itens := []Item{
+		{ID: 1, Nome: "Porca", Prateleira: "P-0002", Estante: "L1", Compartimento: "10", Quantidade: 5},
+		{ID: 2, Nome: "arruela", Prateleira: "P-0002", Estante: "L1", Compartimento: "2", Quantidade: 1},
+		{ID: 3, Nome: "Ímã", Prateleira: "P-0001", Estante: "L10", Compartimento: "1", Quantidade: 5},
+		{ID: 4, Nome: "lixa", Prateleira: "P-0001", Estante: "L2", Compartimento: "1", Quantidade: 0},
+	}
+	testes := []struct {
+		ordem string
+		ids   []int
+	}{
+		{"nome", []int{2, 3, 4, 1}},
+		{"-nome", []int{1, 4, 3, 2}},
+		{"local", []int{4, 3, 2, 1}},
+		{"-local", []int{1, 2, 3, 4}},
+		{"quantidade", []int{4, 2, 1, 3}},  // 1 and 3 tie and keep their order
+		{"-quantidade", []int{1, 3, 2, 4}}, // and also when reversed
+		{"desconhecida", []int{1, 2, 3, 4}},
+	}
+	for _, tt := range testes {
+		ordenados := append([]Item(nil), itens...)
+		ordenarItens(ordenados, tt.ordem)
+		var ids []int
+		for _, item := range ordenados {
+			ids = append(ids, item.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("ordenarItens(%q) = %v, want %v", tt.ordem, ids, tt.ids)
+		}
+	}
+}
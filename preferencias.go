@@ -0,0 +1,213 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PreferenciasUsuario are a user's settings for the item list.
+type PreferenciasUsuario struct {
+	ItensPorPagina int    `json:"itens_por_pagina,omitempty"` // zero follows items_per_page in config.json
+	Ordem          string `json:"ordem,omitempty"`            // default sort, one of ordensItens
+	Vista          string `json:"vista,omitempty"`            // "lista" for a table, a grid of cards otherwise
+}
+
+// BuscaSalva is a named search: the query, filters and sort of the item list.
+type BuscaSalva struct {
+	Nome       string `json:"nome"`
+	Parametros string `json:"parametros"` // URL query of "/", e.g. q=m4&rack=P-0001&ordem=nome
+}
+
+func (b BuscaSalva) URL() template.URL {
+	return template.URL("/?" + b.Parametros)
+}
+
+// Resumo shows the parameters readably, e.g. "q=m4 · rack=P-0001".
+func (b BuscaSalva) Resumo() string {
+	texto, err := url.QueryUnescape(b.Parametros)
+	if err != nil {
+		return b.Parametros
+	}
+	return strings.ReplaceAll(texto, "&", " · ")
+}
+
+// parametrosLista are the URL parameters that make up the state of the item
+// list, which is what a saved search keeps.
+var parametrosLista = []string{"q", "categoria", "tag", "estante", "rack", "foto", "estoque", "ordem"}
+
+// estadoLista keeps only the item list parameters that have a value, except
+// ordem, which may be set to empty to override the default sort.
+func estadoLista(valores url.Values) url.Values {
+	estado := url.Values{}
+	for _, p := range parametrosLista {
+		if v := strings.TrimSpace(valores.Get(p)); v != "" || (p == "ordem" && valores.Has(p)) {
+			estado.Set(p, v)
+		}
+	}
+	return estado
+}
+
+// usuarioDaSessao returns the logged in user, or nil.
+func usuarioDaSessao(r *http.Request) *Usuario {
+	session, _ := store.Get(r, "session")
+	username, _ := session.Values["username"].(string)
+	for i := range usuariosData.Usuarios {
+		if usuariosData.Usuarios[i].Username == username {
+			return &usuariosData.Usuarios[i]
+		}
+	}
+	return nil
+}
+
+func preferenciasDaSessao(r *http.Request) PreferenciasUsuario {
+	if u := usuarioDaSessao(r); u != nil {
+		return u.Preferencias
+	}
+	return PreferenciasUsuario{}
+}
+
+// ordemDaLista returns the sort of the item list: the user's default unless
+// the URL asks for another.
+func ordemDaLista(r *http.Request) string {
+	ordem := preferenciasDaSessao(r).Ordem
+	if r.URL.Query().Has("ordem") {
+		ordem = r.URL.Query().Get("ordem")
+	}
+	if !ordemValida(ordem) {
+		return ""
+	}
+	return ordem
+}
+
+func editarPreferencias(w http.ResponseWriter, r *http.Request) {
+	usuario := usuarioDaSessao(r)
+	if usuario == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		renderPreferencias(w, r, "", "")
+		return
+	}
+	r.ParseForm()
+
+	var p PreferenciasUsuario
+	if valor := strings.TrimSpace(r.FormValue("itens_por_pagina")); valor != "" {
+		n, err := strconv.Atoi(valor)
+		if err != nil || n < 1 || n > 500 {
+			renderPreferencias(w, r, "Items per page must be a whole number from 1 to 500, or empty for the default", "")
+			return
+		}
+		p.ItensPorPagina = n
+	}
+	if ordem := r.FormValue("ordem"); ordemValida(ordem) {
+		p.Ordem = ordem
+	}
+	if r.FormValue("vista") == "lista" {
+		p.Vista = "lista"
+	}
+	usuario.Preferencias = p
+	salvarUsuarios()
+	renderPreferencias(w, r, "", "Preferences saved")
+}
+
+func renderPreferencias(w http.ResponseWriter, r *http.Request, erro, sucesso string) {
+	usuario := usuarioDaSessao(r)
+	tmpl := template.Must(template.ParseFiles("templates/preferencias.html"))
+	tmpl.Execute(w, struct {
+		Preferencias PreferenciasUsuario
+		BuscasSalvas []BuscaSalva
+		Ordens       []OrdemItens
+		Padrao       int
+		Error        string
+		Sucesso      string
+	}{
+		Preferencias: usuario.Preferencias,
+		BuscasSalvas: usuario.BuscasSalvas,
+		Ordens:       ordensItens,
+		Padrao:       config.ItemsPerPage,
+		Error:        erro,
+		Sucesso:      sucesso,
+	})
+}
+
+// alternarVista switches between the grid and the table from the item list
+// and remembers the choice.
+func alternarVista(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if usuario := usuarioDaSessao(r); usuario != nil {
+		usuario.Preferencias.Vista = ""
+		if r.FormValue("vista") == "lista" {
+			usuario.Preferencias.Vista = "lista"
+		}
+		salvarUsuarios()
+	}
+	http.Redirect(w, r, voltarPara(r), http.StatusSeeOther)
+}
+
+// voltarPara returns the local page a form asked to go back to.
+func voltarPara(r *http.Request) string {
+	voltar := r.FormValue("voltar")
+	if !strings.HasPrefix(voltar, "/") || strings.HasPrefix(voltar, "//") || strings.HasPrefix(voltar, "/\\") {
+		return "/"
+	}
+	return voltar
+}
+
+// salvarBusca stores the current search of the item list under a name,
+// replacing a saved search of the same name.
+func salvarBusca(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	usuario := usuarioDaSessao(r)
+	if usuario == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	nome := strings.TrimSpace(r.FormValue("nome"))
+	if nome == "" {
+		http.Error(w, "Give the search a name", http.StatusBadRequest)
+		return
+	}
+	valores, _ := url.ParseQuery(r.FormValue("parametros"))
+	busca := BuscaSalva{Nome: nome, Parametros: estadoLista(valores).Encode()}
+
+	substituida := false
+	for i, b := range usuario.BuscasSalvas {
+		if b.Nome == nome {
+			usuario.BuscasSalvas[i] = busca
+			substituida = true
+		}
+	}
+	if !substituida {
+		usuario.BuscasSalvas = append(usuario.BuscasSalvas, busca)
+	}
+	salvarUsuarios()
+	http.Redirect(w, r, string(busca.URL()), http.StatusSeeOther)
+}
+
+func deletarBusca(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if usuario := usuarioDaSessao(r); usuario != nil {
+		var buscas []BuscaSalva
+		for _, b := range usuario.BuscasSalvas {
+			if b.Nome != r.FormValue("nome") {
+				buscas = append(buscas, b)
+			}
+		}
+		usuario.BuscasSalvas = buscas
+		salvarUsuarios()
+	}
+	http.Redirect(w, r, "/preferencias", http.StatusSeeOther)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArmazenamentoS3 keeps the photos in a bucket of an S3-compatible service,
+// using path-style URLs and AWS Signature Version 4.
+type ArmazenamentoS3 struct {
+	endpoint  *url.URL
+	regiao    string
+	bucket    string
+	prefixo   string
+	accessKey string
+	secretKey string
+	cliente   *http.Client
+}
+
+func novoArmazenamentoS3(c ConfigArmazenamento) (*ArmazenamentoS3, error) {
+	if c.Endpoint == "" || c.Bucket == "" {
+		return nil, errors.New("s3 photo storage needs endpoint and bucket")
+	}
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return nil, errors.New("s3 photo storage needs access_key and secret_key")
+	}
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", c.Endpoint)
+	}
+	regiao := c.Regiao
+	if regiao == "" {
+		regiao = "us-east-1"
+	}
+	prefixo := strings.Trim(c.Prefixo, "/")
+	if prefixo != "" {
+		prefixo += "/"
+	}
+	return &ArmazenamentoS3{
+		endpoint:  endpoint,
+		regiao:    regiao,
+		bucket:    c.Bucket,
+		prefixo:   prefixo,
+		accessKey: c.AccessKey,
+		secretKey: c.SecretKey,
+		cliente:   &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+func (s *ArmazenamentoS3) urlObjeto(nome string) *url.URL {
+	u := *s.endpoint
+	u.Path = "/" + s.bucket + "/" + s.prefixo + path.Clean("/" + nome)[1:]
+	// Send the path exactly as it is signed
+	u.RawPath = codificarURI(u.Path, false)
+	return &u
+}
+
+func (s *ArmazenamentoS3) requisitar(metodo string, u *url.URL, corpo []byte, cabecalhos map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(metodo, u.String(), bytes.NewReader(corpo))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range cabecalhos {
+		req.Header.Set(k, v)
+	}
+	s.assinar(req, corpo, time.Now().UTC())
+	return s.cliente.Do(req)
+}
+
+// erroS3 turns a failed response into an error, fs.ErrNotExist for 404.
+func erroS3(resp *http.Response, metodo, nome string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fs.ErrNotExist
+	}
+	corpo, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 %s %s: %s %s", metodo, nome, resp.Status, strings.TrimSpace(string(corpo)))
+}
+
+func (s *ArmazenamentoS3) Salvar(nome string, conteudo []byte) error {
+	tipo := mime.TypeByExtension(path.Ext(nome))
+	if tipo == "" {
+		tipo = "application/octet-stream"
+	}
+	resp, err := s.requisitar(http.MethodPut, s.urlObjeto(nome), conteudo, map[string]string{"Content-Type": tipo})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return erroS3(resp, "PUT", nome)
+	}
+	return nil
+}
+
+func (s *ArmazenamentoS3) Abrir(nome string) (io.ReadCloser, error) {
+	resp, err := s.requisitar(http.MethodGet, s.urlObjeto(nome), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, erroS3(resp, "GET", nome)
+	}
+	return resp.Body, nil
+}
+
+func (s *ArmazenamentoS3) Existe(nome string) (bool, error) {
+	resp, err := s.requisitar(http.MethodHead, s.urlObjeto(nome), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return true, nil
+	}
+	if err := erroS3(resp, "HEAD", nome); !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return false, nil
+}
+
+func (s *ArmazenamentoS3) Remover(nome string) error {
+	resp, err := s.requisitar(http.MethodDelete, s.urlObjeto(nome), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return erroS3(resp, "DELETE", nome)
+	}
+	return nil
+}
+
+func (s *ArmazenamentoS3) Listar() ([]ObjetoArmazenado, error) {
+	var objetos []ObjetoArmazenado
+	token := ""
+	for {
+		u := *s.endpoint
+		u.Path = "/" + s.bucket
+		q := url.Values{"list-type": {"2"}, "prefix": {s.prefixo}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+
+		resp, err := s.requisitar(http.MethodGet, &u, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 != 2 {
+			defer resp.Body.Close()
+			return nil, erroS3(resp, "LIST", s.bucket)
+		}
+		var lista struct {
+			Contents []struct {
+				Key  string
+				Size int64
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&lista)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range lista.Contents {
+			nome := strings.TrimPrefix(c.Key, s.prefixo)
+			if strings.HasPrefix(path.Base(nome), ".") {
+				continue
+			}
+			objetos = append(objetos, ObjetoArmazenado{Nome: nome, Tamanho: c.Size})
+		}
+		if !lista.IsTruncated || lista.NextContinuationToken == "" {
+			return objetos, nil
+		}
+		token = lista.NextContinuationToken
+	}
+}
+
+// URLAssinada returns a presigned GET URL valid for the given duration.
+func (s *ArmazenamentoS3) URLAssinada(nome string, validade time.Duration) (string, error) {
+	return s.preassinar(s.urlObjeto(nome), validade, time.Now().UTC()), nil
+}
+
+// preassinar adds the Signature Version 4 query parameters of a GET valid
+// from agora for the given duration.
+func (s *ArmazenamentoS3) preassinar(u *url.URL, validade time.Duration, agora time.Time) string {
+	q := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + s.escopo(agora)},
+		"X-Amz-Date":          {agora.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(validade.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u.RawQuery = consultaCanonica(q)
+	canonica := strings.Join([]string{
+		http.MethodGet,
+		caminhoCanonico(u.Path),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + s.assinatura(agora, canonica)
+	return u.String()
+}
+
+func (s *ArmazenamentoS3) escopo(t time.Time) string {
+	return t.Format("20060102") + "/" + s.regiao + "/s3/aws4_request"
+}
+
+// assinar adds the Signature Version 4 headers to the request.
+func (s *ArmazenamentoS3) assinar(req *http.Request, corpo []byte, agora time.Time) {
+	hashCorpo := sha256.Sum256(corpo)
+	req.Header.Set("X-Amz-Date", agora.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hashCorpo[:]))
+
+	cabecalhos := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "content-type" || strings.HasPrefix(k, "x-amz-") {
+			cabecalhos[k] = strings.TrimSpace(v[0])
+		}
+	}
+	var nomes []string
+	for k := range cabecalhos {
+		nomes = append(nomes, k)
+	}
+	sort.Strings(nomes)
+	var canonicos strings.Builder
+	for _, k := range nomes {
+		canonicos.WriteString(k + ":" + cabecalhos[k] + "\n")
+	}
+	assinados := strings.Join(nomes, ";")
+
+	canonica := strings.Join([]string{
+		req.Method,
+		caminhoCanonico(req.URL.Path),
+		consultaCanonica(req.URL.Query()),
+		canonicos.String(),
+		assinados,
+		hex.EncodeToString(hashCorpo[:]),
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, s.escopo(agora), assinados, s.assinatura(agora, canonica)))
+}
+
+func (s *ArmazenamentoS3) assinatura(agora time.Time, requisicaoCanonica string) string {
+	hashRequisicao := sha256.Sum256([]byte(requisicaoCanonica))
+	texto := "AWS4-HMAC-SHA256\n" + agora.Format("20060102T150405Z") + "\n" + s.escopo(agora) + "\n" + hex.EncodeToString(hashRequisicao[:])
+
+	chave := hmacSHA256([]byte("AWS4"+s.secretKey), agora.Format("20060102"))
+	chave = hmacSHA256(chave, s.regiao)
+	chave = hmacSHA256(chave, "s3")
+	chave = hmacSHA256(chave, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(chave, texto))
+}
+
+func hmacSHA256(chave []byte, texto string) []byte {
+	h := hmac.New(sha256.New, chave)
+	h.Write([]byte(texto))
+	return h.Sum(nil)
+}
+
+// codificarURI escapes everything but the unreserved characters, as
+// Signature Version 4 requires.
+func codificarURI(s string, barra bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~' || (c == '/' && !barra) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func caminhoCanonico(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return codificarURI(p, false)
+}
+
+func consultaCanonica(q url.Values) string {
+	var pares []string
+	for k, vs := range q {
+		for _, v := range vs {
+			pares = append(pares, codificarURI(k, true)+"="+codificarURI(v, true))
+		}
+	}
+	sort.Strings(pares)
+	return strings.Join(pares, "&")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResultadoScan is what a scanned code refers to.
+type ResultadoScan struct {
+	Tipo string `json:"tipo"` // "item", "rack" or "estante"
+	ID   int    `json:"id,omitempty"`
+	Nome string `json:"nome"`
+	URL  string `json:"url"`
+}
+
+// resolverCodigo accepts the codes printed on labels (ITEM:6, RACK:P-0001,
+// SHELF:L1), item URLs from QR codes, rack or shelf names and bare item IDs.
+// Names come first, so a shelf called "3" is not taken for item 3.
+func resolverCodigo(codigo string) (ResultadoScan, bool) {
+	codigo = strings.TrimSpace(codigo)
+	if codigo == "" {
+		return ResultadoScan{}, false
+	}
+	maiusculo := strings.ToUpper(codigo)
+	switch {
+	case strings.HasPrefix(maiusculo, prefixoItem):
+		id, err := strconv.Atoi(strings.TrimSpace(codigo[len(prefixoItem):]))
+		if err != nil {
+			return ResultadoScan{}, false
+		}
+		return resultadoItem(id)
+	case strings.HasPrefix(maiusculo, prefixoRack):
+		return resultadoRack(codigo[len(prefixoRack):])
+	case strings.HasPrefix(maiusculo, prefixoEstante):
+		return resultadoEstante(codigo[len(prefixoEstante):])
+	}
+
+	if u, err := url.Parse(codigo); err == nil && strings.HasSuffix(u.Path, "/item") {
+		id, err := strconv.Atoi(u.Query().Get("id"))
+		if err != nil {
+			return ResultadoScan{}, false
+		}
+		return resultadoItem(id)
+	}
+	if r, ok := resultadoRack(codigo); ok {
+		return r, true
+	}
+	if r, ok := resultadoEstante(codigo); ok {
+		return r, true
+	}
+	id, err := strconv.Atoi(codigo)
+	if err != nil {
+		return ResultadoScan{}, false
+	}
+	return resultadoItem(id)
+}
+
+func resultadoItem(id int) (ResultadoScan, bool) {
+	i, ok := buscarItem(id)
+	if !ok {
+		return ResultadoScan{}, false
+	}
+	return ResultadoScan{Tipo: "item", ID: id, Nome: dados.Itens[i].Nome, URL: "/item?id=" + strconv.Itoa(id)}, true
+}
+
+func resultadoRack(nome string) (ResultadoScan, bool) {
+	for _, rack := range dados.Racks {
+		if strings.EqualFold(rack.Nome, strings.TrimSpace(nome)) {
+			return ResultadoScan{Tipo: "rack", Nome: rack.Nome, URL: "/racks/ocupacao?nome=" + url.QueryEscape(rack.Nome)}, true
+		}
+	}
+	return ResultadoScan{}, false
+}
+
+func resultadoEstante(nome string) (ResultadoScan, bool) {
+	for _, e := range dados.Estantes {
+		if strings.EqualFold(e.Nome, strings.TrimSpace(nome)) {
+			return ResultadoScan{Tipo: "estante", Nome: e.Nome, URL: "/mapa"}, true
+		}
+	}
+	return ResultadoScan{}, false
+}
+
+// resolverCodigoJSON is the scan endpoint for scripts and handheld apps,
+// e.g. /scan/resolver?codigo=RACK:P-0001.
+func resolverCodigoJSON(w http.ResponseWriter, r *http.Request) {
+	resultado, ok := resolverCodigo(r.URL.Query().Get("codigo"))
+	if !ok {
+		http.Error(w, "Code not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resultado)
+}
+
+// escanear is the scan page. Scanning a rack or a shelf makes it the target
+// location, kept in the form, so items scanned afterwards can be moved there.
+func escanear(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	renderEscanear(w, r, r.FormValue("codigo"), "", "")
+}
+
+func renderEscanear(w http.ResponseWriter, r *http.Request, codigo, erro, sucesso string) {
+	rack := r.FormValue("rack")
+	estante := r.FormValue("estante")
+
+	var item *Item
+	if codigo != "" {
+		resultado, ok := resolverCodigo(codigo)
+		switch {
+		case !ok:
+			if erro == "" {
+				erro = fmt.Sprintf("Nothing matches %q", codigo)
+			}
+		case resultado.Tipo == "rack":
+			rack = resultado.Nome
+		case resultado.Tipo == "estante":
+			estante = resultado.Nome
+		default:
+			i, _ := buscarItem(resultado.ID)
+			item = &dados.Itens[i]
+		}
+	}
+
+	var itensLocal []Item
+	if rack != "" && estante != "" {
+		for _, it := range dados.Itens {
+			if it.Prateleira == rack && it.Estante == estante {
+				itensLocal = append(itensLocal, it)
+			}
+		}
+	}
+
+	tmpl := template.Must(template.ParseFiles("templates/scan.html"))
+	tmpl.Execute(w, struct {
+		Item       *Item
+		ItensLocal []Item
+		Rack       string
+		Estante    string
+		Role       string
+		Error      string
+		Sucesso    string
+	}{
+		Item:       item,
+		ItensLocal: itensLocal,
+		Rack:       rack,
+		Estante:    estante,
+		Role:       getUserRole(r),
+		Error:      erro,
+		Sucesso:    sucesso,
+	})
+}
+
+// acaoEscanear runs the quick actions of the scan page: take one, add
+// stock and move the item to the target location.
+func acaoEscanear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	codigo := codigoItem(id)
+	i, ok := buscarItem(id)
+	if !ok {
+		renderEscanear(w, r, "", "Item not found", "")
+		return
+	}
+	item := &dados.Itens[i]
+
+	switch r.FormValue("acao") {
+	case "retirar":
+		if item.Quantidade < 1 {
+			renderEscanear(w, r, codigo, item.Nome+" is out of stock", "")
+			return
+		}
+		item.Quantidade--
+		item.Modificado = time.Now()
+		salvarDados()
+		registrarAuditoria(r, "stock", []int{id}, fmt.Sprintf("Took 1, %d left", item.Quantidade))
+		renderEscanear(w, r, codigo, "", fmt.Sprintf("Took one %s, %d left", item.Nome, item.Quantidade))
+	case "adicionar":
+		n, err := strconv.Atoi(r.FormValue("quantidade"))
+		if err != nil || n < 1 {
+			renderEscanear(w, r, codigo, "Enter how many units to add", "")
+			return
+		}
+		item.Quantidade += n
+		item.Modificado = time.Now()
+		salvarDados()
+		registrarAuditoria(r, "stock", []int{id}, fmt.Sprintf("Added %d, %d in stock", n, item.Quantidade))
+		renderEscanear(w, r, codigo, "", fmt.Sprintf("Added %d to %s, %d in stock", n, item.Nome, item.Quantidade))
+	case "mover":
+		rack, estante := r.FormValue("rack"), r.FormValue("estante")
+		if rack == "" || estante == "" {
+			renderEscanear(w, r, codigo, "Scan a rack and a shelf first", "")
+			return
+		}
+		if item.Prateleira == rack && item.Estante == estante {
+			renderEscanear(w, r, codigo, "", item.Nome+" is already there")
+			return
+		}
+		plano, conflitos := planejarMovimento([]int{id}, rack, estante)
+		if len(conflitos) > 0 {
+			renderEscanear(w, r, codigo, strings.Join(conflitos, "; "), "")
+			return
+		}
+		item.Prateleira = rack
+		item.Estante = estante
+		item.Compartimento = plano[0].Compartimento
+		item.Modificado = time.Now()
+		salvarDados()
+		registrarAuditoria(r, "move", []int{id}, fmt.Sprintf("Rack %s, Shelf %s", rack, estante))
+		renderEscanear(w, r, codigo, "", fmt.Sprintf("Moved %s to Rack %s, Shelf %s, Compartment %s", item.Nome, rack, estante, item.Compartimento))
+	default:
+		renderEscanear(w, r, codigo, "Unknown action", "")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+func limiteUpload() int64 {
+	mb := config.MaxUploadMB
+	if mb <= 0 {
+		mb = 50
+	}
+	return int64(mb) << 20
+}
+
+func limitePixels() int {
+	mp := config.MaxImageMegapixels
+	if mp <= 0 {
+		mp = 40
+	}
+	return mp * 1000 * 1000
+}
+
+// inicioCorpo keeps the first bytes written to it and drops the rest.
+type inicioCorpo struct {
+	bytes.Buffer
+	limite int
+}
+
+func (b *inicioCorpo) Write(p []byte) (int, error) {
+	if resta := b.limite - b.Len(); resta > 0 {
+		b.Buffer.Write(p[:min(len(p), resta)])
+	}
+	return len(p), nil
+}
+
+// lerFormularioUpload caps the size of the whole request and parses the
+// multipart form. The error it returns is meant to be shown to the user.
+func lerFormularioUpload(w http.ResponseWriter, r *http.Request) error {
+	inicio := &inicioCorpo{limite: 1 << 20}
+	r.Body = http.MaxBytesReader(w, struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r.Body, inicio), r.Body}, limiteUpload())
+	err := r.ParseMultipartForm(10 << 20) // 10MB max memory, the rest goes to temp files
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		recuperarCamposTexto(r, inicio.Bytes())
+		return fmt.Errorf("The upload is larger than %d MB, please send fewer or smaller files", limiteUpload()>>20)
+	}
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return fmt.Errorf("Could not read the form: %v", err)
+	}
+	return nil
+}
+
+// recuperarCamposTexto reads the text fields of a multipart body cut short,
+// so a form rejected for its size can be shown again filled in. Browsers
+// send the fields in page order and the forms put the files last.
+func recuperarCamposTexto(r *http.Request, inicio []byte) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return
+	}
+	if r.Form == nil {
+		r.Form = url.Values{}
+	}
+	r.PostForm = url.Values{}
+	mr := multipart.NewReader(bytes.NewReader(inicio), params["boundary"])
+	for {
+		parte, err := mr.NextPart()
+		if err != nil {
+			return
+		}
+		if parte.FileName() != "" {
+			continue
+		}
+		valor, err := io.ReadAll(parte)
+		if err != nil {
+			return
+		}
+		r.Form.Add(parte.FormName(), string(valor))
+		r.PostForm.Add(parte.FormName(), string(valor))
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// planilhaXLSX writes a single-sheet XLSX workbook row by row. Cells are
+// written as inline strings and numbers, so nothing has to be kept until the
+// end, and the first row is bold and frozen as the header.
+type planilhaXLSX struct {
+	zip   *zip.Writer
+	folha *bufio.Writer
+	linha int
+}
+
+// Parts of the workbook that do not depend on the rows
+var partesXLSX = []struct{ Nome, Conteudo string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Items" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+		`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+		`</styleSheet>`},
+}
+
+func novaPlanilhaXLSX(w io.Writer) (*planilhaXLSX, error) {
+	z := zip.NewWriter(w)
+	for _, parte := range partesXLSX {
+		f, err := z.Create(parte.Nome)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, parte.Conteudo); err != nil {
+			return nil, err
+		}
+	}
+	f, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	p := &planilhaXLSX{zip: z, folha: bufio.NewWriter(f)}
+	p.folha.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>` +
+		`<sheetData>`)
+	return p, nil
+}
+
+// Linha writes the next row. Values are ints or strings; anything else is
+// written as text.
+func (p *planilhaXLSX) Linha(valores ...any) error {
+	p.linha++
+	estilo := ""
+	if p.linha == 1 {
+		estilo = ` s="1"`
+	}
+	fmt.Fprintf(p.folha, `<row r="%d">`, p.linha)
+	for i, v := range valores {
+		celula := colunaXLSX(i) + strconv.Itoa(p.linha)
+		if n, ok := v.(int); ok {
+			fmt.Fprintf(p.folha, `<c r="%s"%s><v>%d</v></c>`, celula, estilo, n)
+			continue
+		}
+		texto := fmt.Sprint(v)
+		if texto == "" {
+			continue
+		}
+		fmt.Fprintf(p.folha, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">`, celula, estilo)
+		xml.EscapeText(p.folha, []byte(texto))
+		p.folha.WriteString(`</t></is></c>`)
+	}
+	_, err := p.folha.WriteString(`</row>`)
+	return err
+}
+
+// Fechar ends the sheet and the workbook.
+func (p *planilhaXLSX) Fechar() error {
+	p.folha.WriteString(`</sheetData></worksheet>`)
+	if err := p.folha.Flush(); err != nil {
+		return err
+	}
+	return p.zip.Close()
+}
+
+// colunaXLSX names a column from its index: A, B, ..., Z, AA, AB, ...
+func colunaXLSX(i int) string {
+	nome := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		nome = string(rune('A'+(i-1)%26)) + nome
+	}
+	return nome
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ConfigImpressora is a thermal label printer reached over raw TCP (port
+// 9100 on Zebra and compatible printers).
+type ConfigImpressora struct {
+	Endereco string  `json:"address"` // host or host:port
+	DPI      int     `json:"dpi"`     // default 203
+	Largura  float64 `json:"width_mm"`
+	Altura   float64 `json:"height_mm"`
+}
+
+func (c ConfigImpressora) endereco() string {
+	if _, _, err := net.SplitHostPort(c.Endereco); err != nil {
+		return net.JoinHostPort(c.Endereco, "9100")
+	}
+	return c.Endereco
+}
+
+// pontos converts millimetres to printer dots.
+func (c ConfigImpressora) pontos(mm, padrao float64) int {
+	dpi := c.DPI
+	if dpi <= 0 {
+		dpi = 203
+	}
+	if mm <= 0 {
+		mm = padrao
+	}
+	return int(mm * float64(dpi) / 25.4)
+}
+
+// DadosZPL is what the ZPL label templates receive. Largura and Altura are
+// the label size in dots.
+type DadosZPL struct {
+	Etiqueta
+	Largura, Altura int
+}
+
+// escaparZPL hex-escapes the characters ZPL treats as commands, for use
+// in fields preceded by ^FH.
+func escaparZPL(s string) string {
+	return strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E").Replace(s)
+}
+
+// gerarZPL renders every label with templates/zpl/item.zpl or
+// templates/zpl/local.zpl, one ^XA...^XZ block per label.
+func gerarZPL(etiquetas []Etiqueta) ([]byte, error) {
+	tmpl, err := template.New("").Funcs(template.FuncMap{
+		"zpl": escaparZPL,
+		"sub": func(a, b int) int { return a - b },
+		"max": func(a, b int) int { return max(a, b) },
+	}).ParseFiles("templates/zpl/item.zpl", "templates/zpl/local.zpl")
+	if err != nil {
+		return nil, err
+	}
+	impressora := config.LabelPrinter
+	var buf bytes.Buffer
+	for _, e := range etiquetas {
+		nome := "local.zpl"
+		if e.Tipo == "item" {
+			nome = "item.zpl"
+		}
+		dados := DadosZPL{
+			Etiqueta: e,
+			Largura:  impressora.pontos(impressora.Largura, 50),
+			Altura:   impressora.pontos(impressora.Altura, 30),
+		}
+		if err := tmpl.ExecuteTemplate(&buf, nome, dados); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func baixarEtiquetasZPL(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	etiquetas := etiquetasSelecionadas(r)
+	if len(etiquetas) == 0 {
+		http.Error(w, "No items or locations selected", http.StatusBadRequest)
+		return
+	}
+	zpl, err := gerarZPL(etiquetas)
+	if err != nil {
+		http.Error(w, "Could not render the labels: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="labels.zpl"`)
+	w.Write(zpl)
+}
+
+// enviarImpressora sends raw ZPL to the configured printer.
+func enviarImpressora(zpl []byte) error {
+	if config.LabelPrinter.Endereco == "" {
+		return fmt.Errorf("no label printer configured, set label_printer.address in config.json")
+	}
+	conn, err := net.DialTimeout("tcp", config.LabelPrinter.endereco(), 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err = conn.Write(zpl)
+	return err
+}
+
+func imprimirEtiquetas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	etiquetas := etiquetasSelecionadas(r)
+	if len(etiquetas) == 0 {
+		renderEtiquetas(w, r, "No items or locations selected", "")
+		return
+	}
+	zpl, err := gerarZPL(etiquetas)
+	if err == nil {
+		err = enviarImpressora(zpl)
+	}
+	if err != nil {
+		renderEtiquetas(w, r, "Could not print the labels: "+err.Error(), "")
+		return
+	}
+	renderEtiquetas(w, r, "", fmt.Sprintf("%d label(s) sent to %s", len(etiquetas), config.LabelPrinter.endereco()))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// impressoraFalsa listens like a raw TCP label printer and hands over
+// everything one connection sends.
+func impressoraFalsa(t *testing.T) (string, <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	recebido := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b, _ := io.ReadAll(conn)
+		recebido <- b
+	}()
+	return ln.Addr().String(), recebido
+}
+
+func usarImpressora(t *testing.T, impressora ConfigImpressora) {
+	anterior := config.LabelPrinter
+	config.LabelPrinter = impressora
+	t.Cleanup(func() { config.LabelPrinter = anterior })
+}
+
+func TestEnviarImpressora(t *testing.T) {
+	endereco, recebido := impressoraFalsa(t)
+	usarImpressora(t, ConfigImpressora{Endereco: endereco})
+
+	zpl, err := gerarZPL([]Etiqueta{
+		{Tipo: "item", Titulo: "M3_screws ^10", Local: "Rack P-0001, Shelf L1, Compartment 3", Codigo: "ITEM:6"},
+		{Tipo: "rack", Titulo: "Rack P-0001", Codigo: "RACK:P-0001"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enviarImpressora(zpl); err != nil {
+		t.Fatal(err)
+	}
+
+	var b []byte
+	select {
+	case b = <-recebido:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the printer received nothing")
+	}
+	if !bytes.Equal(b, zpl) {
+		t.Fatalf("printer received %q, want %q", b, zpl)
+	}
+	texto := string(b)
+	if n := strings.Count(texto, "^XA"); n != 2 {
+		t.Errorf("got %d labels, want 2", n)
+	}
+	for _, esperado := range []string{
+		"^FH^FDM3_5Fscrews _5E10^FS",
+		"^FH^FDITEM:6^FS",
+		"^FH^FDRACK:P-0001^FS",
+		"^PW399",
+	} {
+		if !strings.Contains(texto, esperado) {
+			t.Errorf("missing %q in\n%s", esperado, texto)
+		}
+	}
+}
+
+func TestEnviarImpressoraSemEndereco(t *testing.T) {
+	usarImpressora(t, ConfigImpressora{})
+	if err := enviarImpressora([]byte("^XA^XZ")); err == nil {
+		t.Fatal("expected an error without a configured printer")
+	}
+}
+
+func TestGerarZPLAlturaCodigoBarras(t *testing.T) {
+	alturaBarras := regexp.MustCompile(`\^BCN,(-?\d+),`)
+	testes := []struct {
+		nome   string
+		altura float64 // mm
+		tipo   string
+		barras int
+	}{
+		{"item default size", 0, "item", 74},
+		{"rack default size", 0, "rack", 114},
+		{"short item label", 10, "item", 30},
+		{"short rack label", 10, "rack", 30},
+		{"tall item label", 50, "item", 234},
+	}
+	for _, tt := range testes {
+		t.Run(tt.nome, func(t *testing.T) {
+			usarImpressora(t, ConfigImpressora{Altura: tt.altura})
+			zpl, err := gerarZPL([]Etiqueta{{Tipo: tt.tipo, Titulo: "x", Codigo: "x"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := alturaBarras.FindSubmatch(zpl)
+			if m == nil {
+				t.Fatalf("no barcode in %s", zpl)
+			}
+			if altura, _ := strconv.Atoi(string(m[1])); altura != tt.barras {
+				t.Errorf("barcode height %d, want %d", altura, tt.barras)
+			}
+		})
+	}
+}